@@ -0,0 +1,561 @@
+// Package BoltCache provides an httpcache.Cache persisted in a bbolt
+// database, with an optional byte budget, expiry sweeping, and compaction.
+package BoltCache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+// Inside the cache's bucket, responses live in nested buckets next to the
+// bookkeeping that the budget and the sweeper need:
+//
+//	data/<key>               the response, for the default namespace
+//	ns/<namespace>/<key>     the response, with WithBucketPerNamespace
+//	meta/<key>               size | sequence | discardAt | namespace
+//	order/<sequence>         key, oldest first, for eviction
+//	expiry/<discardAt|seq>   key, soonest first, for the sweeper
+//	size                     total bytes charged, big-endian
+var (
+	dataBucket   = []byte("data")
+	nsBucket     = []byte("ns")
+	metaBucket   = []byte("meta")
+	orderBucket  = []byte("order")
+	expiryBucket = []byte("expiry")
+	sizeKey      = []byte("size")
+)
+
+// metaSize is the fixed part of a meta record, before the namespace.
+const metaSize = 24
+
+// BoltCache stores responses in a bucket of a bbolt database.
+//
+// With WithMaxBytes it keeps the stored keys and responses within a byte
+// budget, evicting entries past their expiry first and then the oldest
+// stored. Eviction order is by age, not by use: recording every read would
+// turn each Get into a disk write.
+//
+// Set and Delete go through db.Batch, so concurrent writers share a commit
+// and its fsync. It is safe for concurrent use by multiple goroutines.
+type BoltCache struct {
+	// mu is held for reading by every operation and for writing while
+	// Compact swaps the database file.
+	mu sync.RWMutex
+	db *bbolt.DB
+	// lost is set, and db is nil, once Compact has closed the database and
+	// could not open it again.
+	lost   error
+	owned  bool // opened by OpenBoltCache; closed and compactable by us
+	bucket []byte
+	cfg    config
+	now    func() time.Time
+
+	stop      chan struct{}
+	workers   sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// Option configures a BoltCache.
+type Option func(*config)
+
+type config struct {
+	maxBytes       int64
+	namespace      func(key string) string
+	sweepEvery     time.Duration
+	compactEvery   time.Duration
+	compactMinFree float64
+	boltOptions    *bbolt.Options
+	batchDelay     time.Duration // negative leaves bbolt's default
+}
+
+// WithMaxBytes bounds the bytes of keys and responses stored. Zero, the
+// default, leaves the cache unbounded.
+func WithMaxBytes(n int64) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.maxBytes = n
+		}
+	}
+}
+
+// WithBucketPerNamespace stores each entry in a nested bucket named by
+// namespace(key), so a whole namespace can be dropped at once with
+// DeleteNamespace. An empty name means the default namespace. ByHost is a
+// ready-made namespace function. The byte budget is shared by all of them.
+//
+// Entries stored under one namespace function are not found under another,
+// so it must stay the same across restarts.
+func WithBucketPerNamespace(namespace func(key string) string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBoltOptions sets the options OpenBoltCache opens the database with.
+func WithBoltOptions(o *bbolt.Options) Option {
+	return func(c *config) {
+		c.boltOptions = o
+	}
+}
+
+// WithMaxBatchDelay sets how long OpenBoltCache's database lets a Set or
+// Delete wait for others to share its commit. bbolt's default is 10ms, which
+// every write pays when there is nothing to batch it with; a cache written
+// from one goroutine at a time may want it lower. For NewBoltCache, set
+// db.MaxBatchDelay instead.
+func WithMaxBatchDelay(d time.Duration) Option {
+	return func(c *config) {
+		if d >= 0 {
+			c.batchDelay = d
+		}
+	}
+}
+
+// ByHost is a namespace function for WithBucketPerNamespace that puts each
+// entry in a namespace named after the host of its URL.
+func ByHost(key string) string {
+	// Keys for methods other than GET carry the method in front of the URL.
+	if i := strings.IndexByte(key, ' '); i >= 0 {
+		key = key[i+1:]
+	}
+	u, err := url.Parse(key)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// NewBoltCache returns a cache backed by bucket within db, creating the
+// bucket if it does not exist. The caller keeps ownership of db, which means
+// Compact is not available; use OpenBoltCache for that.
+//
+// The bucket must be given over to the cache, as it keeps its own layout
+// inside it.
+func NewBoltCache(db *bbolt.DB, bucket string, opts ...Option) (*BoltCache, error) {
+	if db == nil {
+		return nil, errors.New("BoltCache: nil database")
+	}
+	return newBoltCache(db, false, bucket, opts)
+}
+
+// OpenBoltCache opens, or creates, the database file at path and returns a
+// cache backed by bucket within it. The cache owns the database: Close
+// closes it, and Compact can rewrite it.
+func OpenBoltCache(path, bucket string, opts ...Option) (*BoltCache, error) {
+	cfg := config{batchDelay: -1}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	db, err := openDB(path, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("BoltCache: opening %s: %w", path, err)
+	}
+	c, err := newBoltCache(db, true, bucket, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// openBolt is bbolt.Open, replaceable in tests.
+var openBolt = bbolt.Open
+
+func openDB(path string, cfg *config) (*bbolt.DB, error) {
+	db, err := openBolt(path, 0o600, cfg.boltOptions)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.batchDelay >= 0 {
+		db.MaxBatchDelay = cfg.batchDelay
+	}
+	return db, nil
+}
+
+func newBoltCache(db *bbolt.DB, owned bool, bucket string, opts []Option) (*BoltCache, error) {
+	if bucket == "" {
+		return nil, errors.New("BoltCache: empty bucket name")
+	}
+	c := &BoltCache{
+		db:     db,
+		owned:  owned,
+		bucket: []byte(bucket),
+		cfg:    config{batchDelay: -1},
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(&c.cfg)
+	}
+	if c.cfg.compactEvery > 0 && !owned {
+		return nil, errors.New("BoltCache: WithCompaction needs a database opened by OpenBoltCache")
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(c.bucket)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{dataBucket, nsBucket, metaBucket, orderBucket, expiryBucket} {
+			if _, err := root.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BoltCache: creating bucket %q: %w", bucket, err)
+	}
+	c.startMaintenance()
+	return c, nil
+}
+
+// Get returns a copy of the stored response.
+//
+// The copy is required, not an optimisation to skip: bbolt returns a slice
+// pointing into its memory-mapped file, valid only for the life of the
+// transaction. Returning it directly hands the caller memory that bbolt may
+// remap out from under it, which corrupts responses once the database grows.
+func (c *BoltCache) Get(key string) ([]byte, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return nil, false
+	}
+	var value []byte
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(c.bucket)
+		if root == nil {
+			return nil
+		}
+		if data := dataFor(root, c.namespaceOf(key)); data != nil {
+			if v := data.Get([]byte(key)); v != nil {
+				value = bytes.Clone(v)
+			}
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// Set stores the response, evicting older entries if it takes the cache over
+// its budget. A response larger than the whole budget is not stored, and any
+// previous value for key is removed so no stale response is left behind.
+func (c *BoltCache) Set(key string, responseBytes []byte) {
+	var discardAt time.Time
+	if _, at, ok := httpcache.EntryExpiry(responseBytes); ok {
+		discardAt = at
+	}
+	ns := c.namespaceOf(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return
+	}
+	_ = c.db.Batch(func(tx *bbolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists(c.bucket)
+		if err != nil {
+			return err
+		}
+		return c.store(root, key, ns, responseBytes, discardAt)
+	})
+}
+
+// Delete removes the entry.
+//
+// It must run in a writable transaction: in a read-only one, Bucket.Delete
+// fails with "tx not writable", and an implementation that discards that
+// error silently never deletes anything, leaving stale responses forever.
+func (c *BoltCache) Delete(key string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return
+	}
+	_ = c.db.Batch(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(c.bucket)
+		if root == nil {
+			return nil
+		}
+		return remove(root, key)
+	})
+}
+
+// DeleteNamespace removes every entry in the namespace ns, dropping its
+// bucket.
+func (c *BoltCache) DeleteNamespace(ns string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return c.lost
+	}
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(c.bucket)
+		if root == nil {
+			return nil
+		}
+		data := dataFor(root, ns)
+		if data == nil {
+			return nil
+		}
+		var keys []string
+		if err := data.ForEach(func(k, _ []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := remove(root, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Keys returns the keys stored that start with prefix, in key order.
+func (c *BoltCache) Keys(prefix string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var keys []string
+	if c.db == nil {
+		return nil
+	}
+	_ = c.db.View(func(tx *bbolt.Tx) error {
+		root := tx.Bucket(c.bucket)
+		if root == nil {
+			return nil
+		}
+		cur := root.Bucket(metaBucket).Cursor()
+		for k, _ := cur.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cur.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys
+}
+
+// Size returns the bytes of keys and responses stored, as counted against
+// the budget.
+func (c *BoltCache) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var size int64
+	if c.db == nil {
+		return 0
+	}
+	_ = c.db.View(func(tx *bbolt.Tx) error {
+		if root := tx.Bucket(c.bucket); root != nil {
+			size = storedSize(root)
+		}
+		return nil
+	})
+	return size
+}
+
+// Close stops the background sweeper and compactor and, for a cache from
+// OpenBoltCache, closes the database.
+func (c *BoltCache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.workers.Wait()
+		if c.owned {
+			c.mu.Lock()
+			if c.db != nil {
+				err = c.db.Close()
+			}
+			c.mu.Unlock()
+		}
+	})
+	return err
+}
+
+func (c *BoltCache) namespaceOf(key string) string {
+	if c.cfg.namespace == nil {
+		return ""
+	}
+	return c.cfg.namespace(key)
+}
+
+// store writes value under key, replacing any previous entry, and evicts
+// until the cache is within budget.
+func (c *BoltCache) store(root *bbolt.Bucket, key, ns string, value []byte, discardAt time.Time) error {
+	if err := remove(root, key); err != nil {
+		return err
+	}
+	size := int64(len(key) + len(value))
+	if c.cfg.maxBytes > 0 && size > c.cfg.maxBytes {
+		return nil
+	}
+	data, err := createDataFor(root, ns)
+	if err != nil {
+		return err
+	}
+	if err := data.Put([]byte(key), value); err != nil {
+		return err
+	}
+	seq, err := root.NextSequence()
+	if err != nil {
+		return err
+	}
+	m := record{size: size, seq: seq, discardAt: discardAt, ns: ns}
+	if err := root.Bucket(metaBucket).Put([]byte(key), m.encode()); err != nil {
+		return err
+	}
+	if err := root.Bucket(orderBucket).Put(u64(seq), []byte(key)); err != nil {
+		return err
+	}
+	if !discardAt.IsZero() {
+		if err := root.Bucket(expiryBucket).Put(m.expiryKey(), []byte(key)); err != nil {
+			return err
+		}
+	}
+	total := storedSize(root) + size
+	if err := root.Put(sizeKey, u64(uint64(total))); err != nil {
+		return err
+	}
+	for c.cfg.maxBytes > 0 && total > c.cfg.maxBytes {
+		victim, ok := c.victim(root)
+		if !ok {
+			break
+		}
+		if err := remove(root, victim); err != nil {
+			return err
+		}
+		total = storedSize(root)
+	}
+	return nil
+}
+
+// victim returns the entry to evict: one past its expiry if there is one,
+// otherwise the oldest stored.
+func (c *BoltCache) victim(root *bbolt.Bucket) (string, bool) {
+	if k, key := root.Bucket(expiryBucket).Cursor().First(); k != nil {
+		if at := int64(binary.BigEndian.Uint64(k)); !c.now().Before(time.Unix(0, at)) {
+			return string(key), true
+		}
+	}
+	if k, key := root.Bucket(orderBucket).Cursor().First(); k != nil {
+		return string(key), true
+	}
+	return "", false
+}
+
+// remove deletes key's response and bookkeeping, if present.
+func remove(root *bbolt.Bucket, key string) error {
+	meta := root.Bucket(metaBucket)
+	raw := meta.Get([]byte(key))
+	if raw == nil {
+		return nil
+	}
+	m, ok := decodeRecord(raw)
+	if !ok {
+		return meta.Delete([]byte(key))
+	}
+	if data := dataFor(root, m.ns); data != nil {
+		if err := data.Delete([]byte(key)); err != nil {
+			return err
+		}
+		if m.ns != "" {
+			if k, _ := data.Cursor().First(); k == nil {
+				if err := root.Bucket(nsBucket).DeleteBucket([]byte(m.ns)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := root.Bucket(orderBucket).Delete(u64(m.seq)); err != nil {
+		return err
+	}
+	if !m.discardAt.IsZero() {
+		if err := root.Bucket(expiryBucket).Delete(m.expiryKey()); err != nil {
+			return err
+		}
+	}
+	if err := meta.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return root.Put(sizeKey, u64(uint64(storedSize(root)-m.size)))
+}
+
+// dataFor returns the bucket holding namespace ns, or nil if it has none.
+func dataFor(root *bbolt.Bucket, ns string) *bbolt.Bucket {
+	if ns == "" {
+		return root.Bucket(dataBucket)
+	}
+	return root.Bucket(nsBucket).Bucket([]byte(ns))
+}
+
+func createDataFor(root *bbolt.Bucket, ns string) (*bbolt.Bucket, error) {
+	if ns == "" {
+		return root.Bucket(dataBucket), nil
+	}
+	return root.Bucket(nsBucket).CreateBucketIfNotExists([]byte(ns))
+}
+
+func storedSize(root *bbolt.Bucket) int64 {
+	v := root.Get(sizeKey)
+	if len(v) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+// record is an entry's bookkeeping.
+type record struct {
+	size      int64
+	seq       uint64
+	discardAt time.Time // zero if the entry never expires
+	ns        string
+}
+
+func (m record) encode() []byte {
+	buf := make([]byte, metaSize, metaSize+len(m.ns))
+	binary.BigEndian.PutUint64(buf[0:], uint64(m.size))
+	binary.BigEndian.PutUint64(buf[8:], m.seq)
+	if !m.discardAt.IsZero() {
+		binary.BigEndian.PutUint64(buf[16:], uint64(m.discardAt.UnixNano()))
+	}
+	return append(buf, m.ns...)
+}
+
+func decodeRecord(raw []byte) (record, bool) {
+	if len(raw) < metaSize {
+		return record{}, false
+	}
+	m := record{
+		size: int64(binary.BigEndian.Uint64(raw[0:])),
+		seq:  binary.BigEndian.Uint64(raw[8:]),
+		ns:   string(raw[metaSize:]),
+	}
+	if at := binary.BigEndian.Uint64(raw[16:]); at != 0 {
+		m.discardAt = time.Unix(0, int64(at))
+	}
+	return m, true
+}
+
+// expiryKey orders entries by discardAt, breaking ties by sequence.
+func (m record) expiryKey() []byte {
+	return append(u64(uint64(m.discardAt.UnixNano())), u64(m.seq)...)
+}
+
+func u64(n uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, n)
+}
+
+// Compile-time proof that it satisfies the interface.
+var _ httpcache.Cache = (*BoltCache)(nil)
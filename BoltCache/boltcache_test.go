@@ -0,0 +1,298 @@
+package BoltCache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// dumped returns a serialized response dated at epoch.
+func dumped(cacheControl string) []byte {
+	return []byte("HTTP/1.1 200 OK\r\nDate: " + epoch.Format(http.TimeFormat) +
+		"\r\nCache-Control: " + cacheControl + "\r\nContent-Length: 2\r\n\r\nok")
+}
+
+// fast skips the fsync and batching delay of every commit, which would
+// otherwise dominate the run time.
+var fast = []Option{WithBoltOptions(&bbolt.Options{NoSync: true}), WithMaxBatchDelay(0)}
+
+// newTestCache returns a cache whose clock reads epoch.
+func newTestCache(t *testing.T, opts ...Option) *BoltCache {
+	t.Helper()
+	c := openTestCache(t, opts...)
+	c.now = func() time.Time { return epoch }
+	return c
+}
+
+func openTestCache(t *testing.T, opts ...Option) *BoltCache {
+	t.Helper()
+	c, err := OpenBoltCache(filepath.Join(t.TempDir(), "cache.db"), "responses", append(fast, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRoundTrips(t *testing.T) {
+	c := newTestCache(t)
+	c.Set("k", []byte("value"))
+	got, ok := c.Get("k")
+	if !ok || string(got) != "value" {
+		t.Fatalf("Get = %q, %v; want \"value\", true", got, ok)
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("Get on a missing key reported a hit")
+	}
+}
+
+// Regression: Delete used a read-only transaction and silently failed.
+func TestDeleteActuallyDeletes(t *testing.T) {
+	c := newTestCache(t)
+	c.Set("k", []byte("value"))
+	c.Delete("k")
+	if v, ok := c.Get("k"); ok {
+		t.Errorf("entry survived Delete: %q", v)
+	}
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size = %d after deleting everything, want 0", n)
+	}
+	c.Delete("missing") // must not panic
+}
+
+// Regression: Get returned an mmap-backed slice that the database could remap.
+// Growing the file used to corrupt a previously returned value.
+func TestGetSurvivesDatabaseGrowth(t *testing.T) {
+	c := newTestCache(t)
+
+	want := bytes.Repeat([]byte{0xAB}, 8192)
+	c.Set("key", want)
+
+	got, ok := c.Get("key")
+	if !ok {
+		t.Fatal("precondition failed")
+	}
+
+	// Force the database to grow well past its initial mapping.
+	filler := make([]byte, 1<<16)
+	for i := 0; i < 400; i++ {
+		c.Set(fmt.Sprintf("filler-%d", i), filler)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Error("value returned by Get was corrupted after the database grew")
+	}
+}
+
+// The Cache contract requires concurrency safety.
+func TestConcurrentUse(t *testing.T) {
+	c := newTestCache(t, WithMaxBytes(1<<10))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 40; j++ {
+				k := fmt.Sprintf("k%d", j%10)
+				c.Set(k, []byte(fmt.Sprintf("v%d-%d", i, j)))
+				c.Get(k)
+				if j%7 == 0 {
+					c.Delete(k)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := c.Size(); n > 1<<10 {
+		t.Errorf("Size = %d, over the budget", n)
+	}
+}
+
+func TestRejectsBadArguments(t *testing.T) {
+	if _, err := NewBoltCache(nil, "b"); err == nil {
+		t.Error("expected an error for a nil database")
+	}
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "x.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := NewBoltCache(db, ""); err == nil {
+		t.Error("expected an error for an empty bucket name")
+	}
+	if _, err := NewBoltCache(db, "b", WithCompaction(time.Minute, 0.5)); err == nil {
+		t.Error("expected an error for compaction of a database the cache does not own")
+	}
+}
+
+func TestBudgetEvictsOldestFirst(t *testing.T) {
+	c := newTestCache(t, WithMaxBytes(100))
+	value := bytes.Repeat([]byte("x"), 30)
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprint("k", i), value) // 32 bytes each with its key
+	}
+	for i, want := range []bool{false, false, true, true, true} {
+		if _, ok := c.Get(fmt.Sprint("k", i)); ok != want {
+			t.Errorf("k%d present = %v, want %v", i, ok, want)
+		}
+	}
+	if n := c.Size(); n != 96 {
+		t.Errorf("Size = %d, want 96", n)
+	}
+}
+
+func TestBudgetEvictsExpiredFirst(t *testing.T) {
+	c := newTestCache(t, WithMaxBytes(int64(3*len(dumped("max-age=60"))+15)))
+	c.Set("old", dumped("max-age=600"))
+	c.Set("soon", dumped("max-age=60"))
+	c.Set("new", dumped("max-age=600"))
+	c.now = func() time.Time { return epoch.Add(2 * time.Minute) }
+	c.Set("newest", dumped("max-age=600"))
+
+	if _, ok := c.Get("soon"); ok {
+		t.Error("the expired entry survived eviction")
+	}
+	if _, ok := c.Get("old"); !ok {
+		t.Error("the oldest entry was evicted although an expired one could go")
+	}
+}
+
+func TestOversizedValueIsNotStored(t *testing.T) {
+	c := newTestCache(t, WithMaxBytes(16))
+	c.Set("k", []byte("v"))
+	c.Set("k", bytes.Repeat([]byte("x"), 32))
+	if _, ok := c.Get("k"); ok {
+		t.Error("an oversized value was stored, or the old value kept")
+	}
+	if n := c.Size(); n != 0 {
+		t.Errorf("Size = %d, want 0", n)
+	}
+}
+
+// The budget and eviction order outlive the process.
+func TestStatePersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := OpenBoltCache(path, "responses", append(fast, WithMaxBytes(100))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("x"), 30)
+	c.Set("k0", value)
+	c.Set("k1", value)
+	c.Close()
+
+	c, err = OpenBoltCache(path, "responses", append(fast, WithMaxBytes(100))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if n := c.Size(); n != 64 {
+		t.Errorf("Size after reopening = %d, want 64", n)
+	}
+	c.Set("k2", value)
+	c.Set("k3", value)
+	if _, ok := c.Get("k0"); ok {
+		t.Error("the oldest entry from before the reopen was not evicted first")
+	}
+}
+
+func TestBucketPerNamespace(t *testing.T) {
+	c := newTestCache(t, WithBucketPerNamespace(ByHost))
+	c.Set("https://a.example/1", []byte("a1"))
+	c.Set("https://a.example/2", []byte("a2"))
+	c.Set("POST https://b.example/1", []byte("b1"))
+	c.Set("not a url\x7f", []byte("default"))
+
+	if err := c.DeleteNamespace("a.example"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{
+		"https://a.example/1":      false,
+		"https://a.example/2":      false,
+		"POST https://b.example/1": true,
+		"not a url\x7f":            true,
+	} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%q present = %v, want %v", key, ok, want)
+		}
+	}
+	if n, want := c.Size(), int64(len("POST https://b.example/1b1")+len("not a url\x7fdefault")); n != want {
+		t.Errorf("Size = %d, want %d", n, want)
+	}
+	c.Set("https://a.example/1", []byte("again"))
+	if v, ok := c.Get("https://a.example/1"); !ok || string(v) != "again" {
+		t.Errorf("Get after recreating the namespace = %q, %v", v, ok)
+	}
+}
+
+func TestKeysFiltersByPrefix(t *testing.T) {
+	c := newTestCache(t, WithBucketPerNamespace(ByHost))
+	for _, key := range []string{"https://a.example/2", "https://a.example/1", "https://b.example/1"} {
+		c.Set(key, []byte("v"))
+	}
+	got := c.Keys("https://a.")
+	if want := []string{"https://a.example/1", "https://a.example/2"}; !slices.Equal(got, want) {
+		t.Errorf("Keys = %q, want %q", got, want)
+	}
+}
+
+// A cache on a database the caller opened leaves the database open.
+func TestNewBoltCacheLeavesDatabaseOpen(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "x.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	c, err := NewBoltCache(db, "responses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("k", []byte("v"))
+	c.Close()
+	if err := db.View(func(*bbolt.Tx) error { return nil }); err != nil {
+		t.Errorf("database unusable after Close: %v", err)
+	}
+	if err := c.Compact(); err == nil {
+		t.Error("Compact of a database the cache does not own succeeded")
+	}
+}
+
+func TestServesTransport(t *testing.T) {
+	var hits atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+
+	client := httpcache.NewTransport(newTestCache(t)).Client()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello" {
+			t.Fatalf("request %d body = %q", i+1, body)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("origin saw %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,15 @@
+// BoltCache is a separate module so that the httpcache library keeps its
+// single dependency: only users who want a bbolt-backed cache pull in bbolt.
+module github.com/ferocious-space/httpcache/BoltCache
+
+go 1.26
+
+require (
+	github.com/ferocious-space/httpcache v0.0.0-20261019090956-eaf09c024f73
+	go.etcd.io/bbolt v1.5.0
+)
+
+require (
+	golang.org/x/sync v0.22.0 // indirect
+	golang.org/x/sys v0.45.0 // indirect
+)
@@ -0,0 +1,201 @@
+package BoltCache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// sweepBatch bounds how many entries one sweep transaction removes, so a
+// large backlog of expired entries does not hold the write lock for long.
+const sweepBatch = 1000
+
+// compactTxMaxSize is how many bytes Compact copies per transaction.
+const compactTxMaxSize = 64 << 20
+
+// WithExpirySweep starts a goroutine that, every interval, removes entries
+// past their freshness lifetime plus any stale-if-error window, read from
+// each response's headers as it was stored. The transport can no longer
+// serve such entries without revalidating. Close the cache to stop it.
+func WithExpirySweep(interval time.Duration) Option {
+	return func(c *config) {
+		if interval > 0 {
+			c.sweepEvery = interval
+		}
+	}
+}
+
+// WithCompaction starts a goroutine that, every interval, compacts the
+// database once at least minFree of its file is free pages. bbolt never
+// shrinks its file: pages freed by deletes and evictions are reused but
+// never returned to the file system. Only a cache from OpenBoltCache can be
+// compacted. Close the cache to stop it.
+func WithCompaction(interval time.Duration, minFree float64) Option {
+	return func(c *config) {
+		if interval > 0 {
+			c.compactEvery = interval
+			c.compactMinFree = minFree
+		}
+	}
+}
+
+func (c *BoltCache) startMaintenance() {
+	if c.cfg.sweepEvery > 0 {
+		c.every(c.cfg.sweepEvery, func() { c.Sweep() })
+	}
+	if c.cfg.compactEvery > 0 {
+		c.every(c.cfg.compactEvery, func() {
+			if c.freeFraction() >= c.cfg.compactMinFree {
+				c.Compact()
+			}
+		})
+	}
+}
+
+func (c *BoltCache) every(interval time.Duration, fn func()) {
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Sweep removes every entry past its expiry, as WithExpirySweep does on a
+// schedule, and returns how many it removed.
+func (c *BoltCache) Sweep() (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return 0, c.lost
+	}
+	removed := 0
+	for {
+		n := 0
+		err := c.db.Update(func(tx *bbolt.Tx) error {
+			root := tx.Bucket(c.bucket)
+			if root == nil {
+				return nil
+			}
+			now := c.now()
+			var keys []string
+			cur := root.Bucket(expiryBucket).Cursor()
+			for k, key := cur.First(); k != nil && len(keys) < sweepBatch; k, key = cur.Next() {
+				if now.Before(time.Unix(0, int64(binary.BigEndian.Uint64(k)))) {
+					break
+				}
+				keys = append(keys, string(key))
+			}
+			for _, key := range keys {
+				if err := remove(root, key); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		removed += n
+		if err != nil || n < sweepBatch {
+			return removed, err
+		}
+	}
+}
+
+// Compact rewrites the database into a fresh file without its free pages
+// and swaps it in, returning the space to the file system. Every operation
+// waits while it runs. It needs a cache from OpenBoltCache.
+//
+// If no database file can be opened again once the old handle is closed,
+// the cache is lost: Get and Keys miss, Set and Delete do nothing, and
+// Compact, Sweep, and DeleteNamespace return the error.
+func (c *BoltCache) Compact() error {
+	if !c.owned {
+		return errors.New("BoltCache: cannot compact a database the cache does not own")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db == nil {
+		return c.lost
+	}
+	path := c.db.Path()
+	tmp := path + ".compact"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("BoltCache: compacting: %w", err)
+	}
+	dst, err := openDB(tmp, &c.cfg)
+	if err != nil {
+		return fmt.Errorf("BoltCache: compacting: %w", err)
+	}
+	if err := bbolt.Compact(dst, c.db, compactTxMaxSize); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("BoltCache: compacting: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("BoltCache: compacting: %w", err)
+	}
+	if err := c.db.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("BoltCache: compacting: %w", err)
+	}
+	// From here on the old handle is closed, so the cache must end up with
+	// a database open, the compacted one or the original, or be marked
+	// lost; it must never keep the closed handle.
+	if err := os.Rename(tmp, path); err != nil {
+		// The original is still at path and the compacted copy at tmp;
+		// either will do.
+		db, openErr := openDB(path, &c.cfg)
+		if openErr == nil {
+			os.Remove(tmp)
+		} else if db, openErr = openDB(tmp, &c.cfg); openErr != nil {
+			return c.lose(openErr)
+		}
+		c.db = db
+		return fmt.Errorf("BoltCache: compacting: %w", err)
+	}
+	db, err := openDB(path, &c.cfg)
+	if err != nil {
+		return c.lose(err)
+	}
+	c.db = db
+	return nil
+}
+
+// lose records that the database could not be reopened after compaction,
+// so that no operation uses the closed handle.
+func (c *BoltCache) lose(err error) error {
+	c.db = nil
+	c.lost = fmt.Errorf("BoltCache: reopening after compaction: %w", err)
+	return c.lost
+}
+
+// freeFraction returns the share of the database file taken by free pages.
+func (c *BoltCache) freeFraction() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.db == nil {
+		return 0
+	}
+	var size int64
+	_ = c.db.View(func(tx *bbolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	if size == 0 {
+		return 0
+	}
+	return float64(c.db.Stats().FreeAlloc) / float64(size)
+}
@@ -0,0 +1,171 @@
+package BoltCache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestSweepRemovesExpiredEntries(t *testing.T) {
+	c := newTestCache(t)
+	c.Set("short", dumped("max-age=60"))
+	c.Set("grace", dumped("max-age=60, stale-if-error=600"))
+	c.Set("long", dumped("max-age=3600"))
+	c.Set("forever", dumped("max-age=60, stale-if-error"))
+	c.Set("opaque", []byte("not a response"))
+
+	c.now = func() time.Time { return epoch.Add(5 * time.Minute) }
+	n, err := c.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("Sweep removed %d entries, want 1", n)
+	}
+	for key, want := range map[string]bool{
+		"short": false, "grace": true, "long": true, "forever": true, "opaque": true,
+	} {
+		if _, ok := c.Get(key); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+// A replaced entry must not be swept by the deadline of the value it
+// replaced.
+func TestSweepFollowsReplacedEntries(t *testing.T) {
+	c := newTestCache(t)
+	c.Set("k", dumped("max-age=60"))
+	c.Set("k", dumped("max-age=3600"))
+	c.now = func() time.Time { return epoch.Add(5 * time.Minute) }
+	if n, _ := c.Sweep(); n != 0 {
+		t.Errorf("Sweep removed %d entries, want 0", n)
+	}
+	if _, ok := c.Get("k"); !ok {
+		t.Error("the replacement was swept")
+	}
+}
+
+func TestExpirySweepRunsInBackground(t *testing.T) {
+	// On the real clock, an entry dated epoch is long expired.
+	c := openTestCache(t, WithExpirySweep(5*time.Millisecond))
+	c.Set("k", dumped("max-age=60"))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := c.Get("k"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the sweeper never removed the expired entry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Close()
+	c.Close() // must not panic
+}
+
+func TestCompactShrinksFile(t *testing.T) {
+	c := newTestCache(t)
+	value := bytes.Repeat([]byte("x"), 4096)
+	for i := 0; i < 500; i++ {
+		c.Set(fmt.Sprint("k", i), value)
+	}
+	for i := 1; i < 500; i++ {
+		c.Delete(fmt.Sprint("k", i))
+	}
+	before := fileSize(t, c)
+	if f := c.freeFraction(); f < 0.5 {
+		t.Fatalf("free fraction = %.2f after deleting nearly everything", f)
+	}
+
+	if err := c.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if after := fileSize(t, c); after >= before/2 {
+		t.Errorf("file is %d bytes after compaction, was %d", after, before)
+	}
+	if v, ok := c.Get("k0"); !ok || !bytes.Equal(v, value) {
+		t.Error("the surviving entry was lost by compaction")
+	}
+	c.Set("after", []byte("v"))
+	if _, ok := c.Get("after"); !ok {
+		t.Error("the cache is unusable after compaction")
+	}
+}
+
+func TestCompactionRunsInBackground(t *testing.T) {
+	c := openTestCache(t, WithCompaction(5*time.Millisecond, 0.5))
+	value := bytes.Repeat([]byte("x"), 4096)
+	for i := 0; i < 500; i++ {
+		c.Set(fmt.Sprint("k", i), value)
+	}
+	before := fileSize(t, c)
+	for i := 0; i < 500; i++ {
+		c.Delete(fmt.Sprint("k", i))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for fileSize(t, c) >= before/2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the compactor never shrank the file")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func fileSize(t *testing.T, c *BoltCache) int64 {
+	t.Helper()
+	c.mu.RLock()
+	path := c.db.Path()
+	c.mu.RUnlock()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+// failReopen makes every database open after the first n fail, until the
+// test ends.
+func failReopen(t *testing.T, n int) {
+	opened := 0
+	t.Cleanup(func() { openBolt = bbolt.Open })
+	openBolt = func(path string, mode os.FileMode, options *bbolt.Options) (*bbolt.DB, error) {
+		if opened++; opened > n {
+			return nil, errors.New("injected open failure")
+		}
+		return bbolt.Open(path, mode, options)
+	}
+}
+
+func TestCompactThatCannotReopenMarksCacheLost(t *testing.T) {
+	c := openTestCache(t)
+	c.Set("k", []byte("v"))
+	failReopen(t, 1) // the compaction target opens; reopening does not
+
+	if err := c.Compact(); err == nil {
+		t.Fatal("Compact reported success without a database open")
+	}
+	if _, ok := c.Get("k"); ok {
+		t.Error("Get hit on a lost cache")
+	}
+	c.Set("k2", []byte("v")) // must not panic or use the closed handle
+	for name, err := range map[string]error{
+		"Compact":         c.Compact(),
+		"DeleteNamespace": c.DeleteNamespace(""),
+	} {
+		if err == nil {
+			t.Errorf("%s on a lost cache returned nil", name)
+		}
+	}
+	if _, err := c.Sweep(); err == nil {
+		t.Error("Sweep on a lost cache returned nil")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close of a lost cache: %v", err)
+	}
+}
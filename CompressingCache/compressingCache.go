@@ -0,0 +1,213 @@
+// Package CompressingCache provides an httpcache.Cache wrapper that
+// compresses stored responses, so a byte-bounded backend such as LruCache
+// holds more of them.
+package CompressingCache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/internal/nilcache"
+)
+
+// A Codec compresses entries. Every entry is tagged with the ID of the codec
+// that wrote it, so entries stay readable after the configured codec changes
+// as long as the old codec is still registered.
+type Codec interface {
+	// ID identifies the codec in stored entries. Zero is reserved for
+	// entries stored uncompressed.
+	ID() byte
+	Encode(src []byte) ([]byte, error)
+	Decode(src []byte) ([]byte, error)
+}
+
+// Flate is the DEFLATE codec (RFC 1951) at the default compression level. It
+// is the default.
+var Flate Codec = flateCodec{}
+
+// Gzip is the gzip codec (RFC 1952) at the default compression level.
+var Gzip Codec = gzipCodec{}
+
+const tagRaw = 0
+
+// DefaultMinSize is the smallest value compressed when no WithMinSize option
+// is given. Below it the codec's framing tends to cost more than it saves.
+const DefaultMinSize = 512
+
+// CompressingCache compresses values on their way into an underlying cache
+// and decompresses them on the way out.
+//
+// Values smaller than the minimum size, values that do not shrink, and
+// responses whose body already carries a Content-Encoding are stored as they
+// are, behind a one-byte tag.
+//
+// It is safe for concurrent use if the underlying cache is.
+type CompressingCache struct {
+	cache   httpcache.Cache
+	codec   Codec
+	codecs  map[byte]Codec
+	minSize int
+}
+
+// Option configures a CompressingCache.
+type Option func(*CompressingCache)
+
+// WithCodec selects the codec used for new entries and registers it for
+// reading. Flate and Gzip are always registered for reading.
+func WithCodec(codec Codec) Option {
+	return func(c *CompressingCache) {
+		c.codec = codec
+	}
+}
+
+// WithMinSize sets the smallest value that is compressed.
+func WithMinSize(n int) Option {
+	return func(c *CompressingCache) {
+		c.minSize = n
+	}
+}
+
+// NewCompressingCache returns a cache that stores compressed values in c.
+func NewCompressingCache(c httpcache.Cache, opts ...Option) (*CompressingCache, error) {
+	if nilcache.IsNil(c) {
+		return nil, errors.New("CompressingCache: underlying cache is nil")
+	}
+	cc := &CompressingCache{
+		cache:   c,
+		codec:   Flate,
+		minSize: DefaultMinSize,
+	}
+	for _, o := range opts {
+		o(cc)
+	}
+	if cc.codec == nil {
+		return nil, errors.New("CompressingCache: nil codec")
+	}
+	if cc.codec.ID() == tagRaw {
+		return nil, errors.New("CompressingCache: codec ID 0 is reserved")
+	}
+	cc.codecs = map[byte]Codec{
+		Flate.ID():    Flate,
+		Gzip.ID():     Gzip,
+		cc.codec.ID(): cc.codec,
+	}
+	return cc, nil
+}
+
+// Get returns the decompressed value for key. An entry that cannot be
+// decoded is deleted and reported as a miss.
+func (c *CompressingCache) Get(key string) ([]byte, bool) {
+	stored, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if len(stored) == 0 {
+		c.cache.Delete(key)
+		return nil, false
+	}
+	if stored[0] == tagRaw {
+		return stored[1:], true
+	}
+	codec, ok := c.codecs[stored[0]]
+	if !ok {
+		c.cache.Delete(key)
+		return nil, false
+	}
+	value, err := codec.Decode(stored[1:])
+	if err != nil {
+		c.cache.Delete(key)
+		return nil, false
+	}
+	return value, true
+}
+
+// Set compresses value, when worthwhile, and stores it under key.
+func (c *CompressingCache) Set(key string, value []byte) {
+	if len(value) >= c.minSize && !bodyEncoded(value) {
+		if compressed, err := c.codec.Encode(value); err == nil && len(compressed) < len(value) {
+			c.cache.Set(key, append([]byte{c.codec.ID()}, compressed...))
+			return
+		}
+	}
+	c.cache.Set(key, append([]byte{tagRaw}, value...))
+}
+
+// Delete removes key from the underlying cache.
+func (c *CompressingCache) Delete(key string) {
+	c.cache.Delete(key)
+}
+
+// bodyEncoded reports whether the serialized response in value declares a
+// Content-Encoding other than identity. Such a body is already compressed,
+// and compressing it again would cost CPU for nothing.
+func bodyEncoded(value []byte) bool {
+	end := bytes.Index(value, []byte("\r\n\r\n"))
+	if end < 0 {
+		return false
+	}
+	lines := bytes.Split(value[:end], []byte("\r\n"))
+	for _, line := range lines[1:] { // lines[0] is the status line
+		name, v, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !bytes.EqualFold(bytes.TrimSpace(name), []byte("Content-Encoding")) {
+			continue
+		}
+		if v = bytes.TrimSpace(v); len(v) > 0 && !bytes.EqualFold(v, []byte("identity")) {
+			return true
+		}
+	}
+	return false
+}
+
+type flateCodec struct{}
+
+func (flateCodec) ID() byte { return 1 }
+
+func (flateCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decode(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte { return 2 }
+
+func (gzipCodec) Encode(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,176 @@
+package CompressingCache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/LruCache"
+)
+
+// mapCache is a minimal underlying cache for tests.
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func newMapCache() *mapCache { return &mapCache{m: map[string][]byte{}} }
+func (c *mapCache) Get(k string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[k]
+	return v, ok
+}
+func (c *mapCache) Set(k string, v []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[k] = v
+}
+func (c *mapCache) Delete(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, k)
+}
+
+func jsonResponse(n int, headers string) []byte {
+	body := strings.Repeat(`{"name":"widget","id":42},`, n)
+	return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n%sContent-Length: %d\r\n\r\n%s", headers, len(body), body))
+}
+
+func TestCompressesLargeValues(t *testing.T) {
+	inner := newMapCache()
+	c, err := NewCompressingCache(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := jsonResponse(200, "")
+	c.Set("k", value)
+
+	stored, _ := inner.Get("k")
+	if stored[0] != Flate.ID() {
+		t.Errorf("tag = %d, want the flate codec", stored[0])
+	}
+	if len(stored) >= len(value)/4 {
+		t.Errorf("stored %d bytes for a %d-byte repetitive value", len(stored), len(value))
+	}
+	got, ok := c.Get("k")
+	if !ok || !bytes.Equal(got, value) {
+		t.Fatalf("Get did not round-trip the value: ok=%v, %d bytes", ok, len(got))
+	}
+}
+
+func TestSmallValuesStoredRaw(t *testing.T) {
+	inner := newMapCache()
+	c, _ := NewCompressingCache(inner, WithMinSize(1<<10))
+	value := jsonResponse(2, "")
+	c.Set("k", value)
+
+	stored, _ := inner.Get("k")
+	if stored[0] != tagRaw || !bytes.Equal(stored[1:], value) {
+		t.Errorf("small value was not stored raw behind a zero tag")
+	}
+	if got, ok := c.Get("k"); !ok || !bytes.Equal(got, value) {
+		t.Errorf("Get = %q, %v", got, ok)
+	}
+}
+
+func TestAlreadyEncodedBodyNotRecompressed(t *testing.T) {
+	inner := newMapCache()
+	c, _ := NewCompressingCache(inner)
+	value := jsonResponse(200, "content-encoding: gzip\r\n")
+	c.Set("k", value)
+
+	if stored, _ := inner.Get("k"); stored[0] != tagRaw {
+		t.Errorf("a body with Content-Encoding was compressed again")
+	}
+
+	identity := jsonResponse(200, "Content-Encoding: identity\r\n")
+	c.Set("id", identity)
+	if stored, _ := inner.Get("id"); stored[0] == tagRaw {
+		t.Errorf("Content-Encoding: identity prevented compression")
+	}
+}
+
+func TestOtherRegisteredCodecStillReadable(t *testing.T) {
+	inner := newMapCache()
+	gz, _ := NewCompressingCache(inner, WithCodec(Gzip))
+	value := jsonResponse(100, "")
+	gz.Set("k", value)
+
+	fl, _ := NewCompressingCache(inner)
+	if got, ok := fl.Get("k"); !ok || !bytes.Equal(got, value) {
+		t.Errorf("a gzip entry was unreadable once flate became the codec")
+	}
+}
+
+func TestUndecodableEntryIsMissAndDeleted(t *testing.T) {
+	inner := newMapCache()
+	c, _ := NewCompressingCache(inner)
+	for name, v := range map[string][]byte{
+		"unknown codec": {0x7f, 1, 2, 3},
+		"corrupt flate": append([]byte{Flate.ID()}, 0xff, 0xff, 0xff),
+		"empty":         {},
+	} {
+		inner.Set("k", v)
+		if _, ok := c.Get("k"); ok {
+			t.Errorf("%s: reported a hit", name)
+		}
+		if _, ok := inner.Get("k"); ok {
+			t.Errorf("%s: entry was not deleted", name)
+		}
+	}
+}
+
+func TestNewCompressingCacheRejectsBadArguments(t *testing.T) {
+	if _, err := NewCompressingCache(nil); err == nil {
+		t.Error("expected error for nil cache")
+	}
+	if _, err := NewCompressingCache(LruCache.NewLRUCache(0)); err == nil {
+		t.Error("expected error for typed-nil cache")
+	}
+	if _, err := NewCompressingCache(newMapCache(), WithCodec(nil)); err == nil {
+		t.Error("expected error for nil codec")
+	}
+}
+
+// End to end: the transport must replay a compressed entry byte for byte.
+func TestServesTransport(t *testing.T) {
+	body := strings.Repeat("compressible ", 1000)
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	lru := LruCache.NewLRUCache(1 << 20)
+	c, _ := NewCompressingCache(lru)
+	client := httpcache.NewTransport(c).Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != body {
+			t.Fatalf("request %d: body mismatch (%d bytes)", i+1, len(got))
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	if lru.Size() >= int64(len(body)) {
+		t.Errorf("LruCache holds %d bytes for a %d-byte body: not compressed", lru.Size(), len(body))
+	}
+}
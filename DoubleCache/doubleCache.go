@@ -1,79 +1,69 @@
 // Package DoubleCache provides a wrapper for two httpcache.Cache instances,
 // allowing a small, fast cache for popular objects to fall back to a larger,
-// slower one for less popular objects.
+// slower one for less popular objects, and MultiTier, its generalisation to
+// any number of tiers.
 //
 // Derived from https://github.com/die-net/lrucache/blob/master/twotier/twotier.go
 package DoubleCache
 
 import (
 	"errors"
-	"reflect"
 
 	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/internal/nilcache"
 )
 
 // DoubleCache is a two-tier cache built from two httpcache.Cache instances.
 // Reads are favoured from first; writes go to second and invalidate first.
+// It is the MultiTier
+//
+//	Tier{Cache: first, Write: WriteAround, Promote: true}
+//	Tier{Cache: second, Write: WriteThrough}
+//
+// or, with WithWriteBehind, WriteBack for second.
 //
 // It is safe for concurrent use if both tiers are.
 type DoubleCache struct {
-	first  httpcache.Cache
-	second httpcache.Cache
+	*MultiTier
+}
+
+// WithWriteBehind makes writes to the second tier asynchronous: Set stages
+// the value in the first tier and queues the write to the second, so a slow
+// second tier no longer adds its latency to the caller. Close the cache on
+// shutdown to flush the queue. It has no effect on NewMultiTier, where each
+// tier's write policy is given explicitly.
+func WithWriteBehind() Option {
+	return func(c *config) {
+		c.writeBehind = true
+	}
 }
 
 // NewDoubleCache returns a two-tier cache. Both tiers must be non-nil and
 // must not be the same instance.
-func NewDoubleCache(first, second httpcache.Cache) (*DoubleCache, error) {
-	if isNilCache(first) {
+func NewDoubleCache(first, second httpcache.Cache, opts ...Option) (*DoubleCache, error) {
+	if nilcache.IsNil(first) {
 		return nil, errors.New("DoubleCache: first tier is nil")
 	}
-	if isNilCache(second) {
+	if nilcache.IsNil(second) {
 		return nil, errors.New("DoubleCache: second tier is nil")
 	}
-	if first == second {
+	if sameInstance(first, second) {
 		return nil, errors.New("DoubleCache: both tiers are the same instance")
 	}
-	return &DoubleCache{first: first, second: second}, nil
-}
-
-// isNilCache reports whether c carries no usable value: either an untyped nil
-// interface, or a nil pointer/map/slice/func/channel wrapped in a non-nil
-// interface, which would panic on the first method call.
-func isNilCache(c httpcache.Cache) bool {
-	if c == nil {
-		return true
-	}
-	switch v := reflect.ValueOf(c); v.Kind() {
-	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
-		return v.IsNil()
-	default:
-		return false
+	cfg := config{workers: DefaultWriteBackWorkers, queue: DefaultWriteBackQueue}
+	for _, o := range opts {
+		o(&cfg)
 	}
-}
-
-// Get tries the fast tier first and, on a miss, promotes a hit from the slow
-// tier into the fast one.
-func (c *DoubleCache) Get(key string) ([]byte, bool) {
-	if value, ok := c.first.Get(key); ok {
-		return value, true
+	secondWrite := WriteThrough
+	if cfg.writeBehind {
+		secondWrite = WriteBack
 	}
-	value, ok := c.second.Get(key)
-	if !ok {
-		return nil, false
+	m, err := newMultiTier([]Tier{
+		{Cache: first, Write: WriteAround, Promote: true},
+		{Cache: second, Write: secondWrite},
+	}, cfg)
+	if err != nil {
+		return nil, err
 	}
-	c.first.Set(key, value)
-	return value, true
-}
-
-// Set stores the response in the slow tier and invalidates the fast tier, so
-// the next read promotes the authoritative copy.
-func (c *DoubleCache) Set(key string, value []byte) {
-	c.second.Set(key, value)
-	c.first.Delete(key)
-}
-
-// Delete removes the key from both tiers.
-func (c *DoubleCache) Delete(key string) {
-	c.second.Delete(key)
-	c.first.Delete(key)
+	return &DoubleCache{MultiTier: m}, nil
 }
@@ -1,8 +1,13 @@
 package DoubleCache
 
 import (
+	"fmt"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ferocious-space/httpcache"
 	"github.com/ferocious-space/httpcache/LruCache"
@@ -132,3 +137,190 @@ func TestNewDoubleCacheRejectsTypedNilTier(t *testing.T) {
 		t.Error("expected error for typed-nil second tier")
 	}
 }
+
+func TestWriteBehindStagesInFirstTier(t *testing.T) {
+	l1 := LruCache.NewLRUCache(1 << 20)
+	slow := &slowCache{mapCache: newMapCache(), delay: 200 * time.Millisecond}
+	dc, err := NewDoubleCache(l1, slow, WithWriteBehind())
+	if err != nil {
+		t.Fatalf("NewDoubleCache: %v", err)
+	}
+	defer dc.Close()
+
+	start := time.Now()
+	dc.Set("k", []byte("v"))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Set took %v with write-behind", elapsed)
+	}
+	if v, ok := l1.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("first tier = %q, %v while the write is queued, want the staged value", v, ok)
+	}
+	dc.Flush()
+	if v, _ := slow.Get("k"); string(v) != "v" {
+		t.Errorf("second tier = %q after Flush, want %q", v, "v")
+	}
+}
+
+// gatedCache holds every Set until the gate is opened.
+type gatedCache struct {
+	*mapCache
+	gate chan struct{}
+}
+
+func (c *gatedCache) Set(k string, v []byte) {
+	<-c.gate
+	c.mapCache.Set(k, v)
+}
+
+// A write dropped from a full queue must not leave the second tier serving
+// the value it would have replaced.
+func TestWriteBehindDropWhenFull(t *testing.T) {
+	l1 := LruCache.NewLRUCache(1 << 20)
+	second := &gatedCache{mapCache: newMapCache(), gate: make(chan struct{})}
+	second.m["k"] = []byte("old")
+	dc, err := NewDoubleCache(l1, second, WithWriteBehind(),
+		WithWriteBackWorkers(1), WithWriteBackQueue(1), WithFullPolicy(DropWhenFull))
+	if err != nil {
+		t.Fatalf("NewDoubleCache: %v", err)
+	}
+	defer dc.Close()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			dc.Set(fmt.Sprint("k", i), []byte("v"))
+		}
+		dc.Set("k", []byte("new"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Set blocked on a full queue under DropWhenFull")
+	}
+	if dc.DroppedWrites() == 0 {
+		t.Fatal("DroppedWrites() = 0, want some with a stalled second tier")
+	}
+	close(second.gate)
+	dc.Flush()
+	if v, ok := second.Get("k"); ok && string(v) == "old" {
+		t.Error("second tier still holds the value a dropped write replaced")
+	}
+}
+
+func TestWriteBehindCloseFlushes(t *testing.T) {
+	second := &slowCache{mapCache: newMapCache(), delay: 5 * time.Millisecond}
+	dc, err := NewDoubleCache(LruCache.NewLRUCache(1<<20), second, WithWriteBehind())
+	if err != nil {
+		t.Fatalf("NewDoubleCache: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		dc.Set(fmt.Sprint("k", i), []byte("v"))
+	}
+	dc.Close()
+	if n := len(second.m); n != 20 {
+		t.Errorf("second tier holds %d entries after Close, want 20", n)
+	}
+}
+
+// A Get that read the old value from the second tier must not promote it
+// after a concurrent Set has invalidated the first.
+func TestGetDoesNotPromoteOverConcurrentSet(t *testing.T) {
+	first := newMapCache()
+	second := &countingCache{mapCache: newMapCache()}
+	second.m["k"] = []byte("old")
+	dc, err := NewDoubleCache(first, second)
+	if err != nil {
+		t.Fatalf("NewDoubleCache: %v", err)
+	}
+	second.onGet = func() {
+		second.onGet = nil
+		dc.Set("k", []byte("new"))
+		second.mapCache.Set("k", []byte("old")) // the lookup read before the write landed
+	}
+	if v, _ := dc.Get("k"); string(v) != "old" {
+		t.Fatalf("racing Get = %q, want the old value it read", v)
+	}
+	if v, ok := first.Get("k"); ok {
+		t.Errorf("first tier = %q after the racing Get, want nothing promoted", v)
+	}
+}
+
+// yieldingCache yields on every Get and Set, widening the windows between a
+// lookup in the second tier and the promotion that follows it, and between a
+// write to the second tier and the invalidation of the first.
+type yieldingCache struct{ *mapCache }
+
+func (c yieldingCache) Get(k string) ([]byte, bool) {
+	v, ok := c.mapCache.Get(k)
+	runtime.Gosched()
+	return v, ok
+}
+
+func (c yieldingCache) Set(k string, v []byte) {
+	c.mapCache.Set(k, v)
+	runtime.Gosched()
+}
+
+// Hammer a few keys with Sets and Gets at once. Once a Set has returned,
+// the first tier must never again hold an older value for its key.
+func TestConcurrentSetAndGetKeepTiersConsistent(t *testing.T) {
+	for _, writeBehind := range []bool{false, true} {
+		first := newMapCache()
+		var opts []Option
+		if writeBehind {
+			opts = append(opts, WithWriteBehind())
+		}
+		dc, err := NewDoubleCache(first, yieldingCache{newMapCache()}, opts...)
+		if err != nil {
+			t.Fatalf("NewDoubleCache: %v", err)
+		}
+
+		const keys, writes = 4, 2000
+		stop := make(chan struct{})
+		var readers, writers sync.WaitGroup
+		for r := 0; r < 4; r++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					key := fmt.Sprint("k", i%keys)
+					dc.Get(key)
+					if i%3 == 0 {
+						first.Delete(key) // eviction from the fast tier
+					}
+				}
+			}()
+		}
+		var stale atomic.Int64
+		for k := 0; k < keys; k++ {
+			writers.Add(1)
+			go func() {
+				defer writers.Done()
+				key := fmt.Sprint("k", k)
+				for i := 1; i <= writes; i++ {
+					dc.Set(key, []byte(strconv.Itoa(i)))
+					runtime.Gosched()
+					if v, ok := first.Get(key); ok {
+						if n, _ := strconv.Atoi(string(v)); n < i {
+							stale.Add(1)
+						}
+					}
+				}
+			}()
+		}
+		writers.Wait()
+		close(stop)
+		readers.Wait()
+		dc.Close()
+
+		if n := stale.Load(); n > 0 {
+			t.Errorf("write-behind %v: first tier served a superseded value %d times", writeBehind, n)
+		}
+	}
+}
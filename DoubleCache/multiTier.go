@@ -0,0 +1,298 @@
+package DoubleCache
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/internal/nilcache"
+)
+
+// WritePolicy says how a tier of a MultiTier takes part in Set.
+type WritePolicy int
+
+const (
+	// WriteThrough stores the value in the tier before Set returns.
+	WriteThrough WritePolicy = iota
+	// WriteBack stores the value in the tier asynchronously, from a queue
+	// drained by background workers, so a slow tier's latency stays off the
+	// caller's path. Writes to the same key are applied in order.
+	WriteBack
+	// WriteAround leaves the tier out of writes: Set only invalidates the
+	// key, and the tier is filled by promotion on read, if at all.
+	WriteAround
+)
+
+func (p WritePolicy) String() string {
+	switch p {
+	case WriteThrough:
+		return "write-through"
+	case WriteBack:
+		return "write-back"
+	case WriteAround:
+		return "write-around"
+	default:
+		return "unknown"
+	}
+}
+
+// Tier is one level of a MultiTier.
+type Tier struct {
+	Cache httpcache.Cache
+	Write WritePolicy
+	// Promote fills this tier with values found in a slower one on read.
+	Promote bool
+}
+
+// MultiTier is a cache built from any number of tiers, fastest first. Get
+// returns the value from the first tier that has it, promoting it into the
+// faster tiers that ask for promotion. Set and Delete apply to the slowest
+// tier first, so a concurrent Get can never find a faster tier newer than a
+// slower one.
+//
+// While a write to a write-back tier is queued, the value is staged in the
+// faster tiers that have Promote set, which would have been filled by the
+// next read anyway, so it is readable at once.
+//
+// A Get that overlaps a Set or Delete of the same key neither promotes what
+// it read nor records a miss, so a concurrent write can never be undone by a
+// promotion of the value it replaced. For a write-back tier the write counts
+// as in progress until its queued op has been applied.
+//
+// It is safe for concurrent use if every tier is. A MultiTier with
+// write-back tiers runs background workers; Close it to stop them.
+type MultiTier struct {
+	tiers []Tier
+	// stageAbove is the index of the fastest write-back tier, or -1; Set
+	// stages values in the promoting tiers above it.
+	stageAbove int
+	queue      *writeQueue    // nil without write-back tiers
+	absent     *negativeCache // nil without WithNegativeCache
+	writes     *writeGuard
+}
+
+// Option configures a MultiTier or DoubleCache.
+type Option func(*config)
+
+type config struct {
+	workers     int
+	queue       int
+	onFull      FullPolicy
+	writeBehind bool
+	absentTTL   time.Duration
+	absentKeys  int
+}
+
+// WithWriteBackWorkers sets how many goroutines apply queued writes.
+// Writes to one key are always applied by the same worker, in order.
+func WithWriteBackWorkers(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// WithWriteBackQueue bounds how many writes may wait to be applied.
+func WithWriteBackQueue(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.queue = n
+		}
+	}
+}
+
+// WithFullPolicy chooses what happens to a write when the queue is full.
+// The default is BlockWhenFull.
+func WithFullPolicy(p FullPolicy) Option {
+	return func(c *config) {
+		c.onFull = p
+	}
+}
+
+// WithNegativeCache remembers, for ttl, up to maxKeys keys that every tier
+// missed, so looking them up again answers a miss without consulting the
+// tiers: for a remote tier, that saves a round trip per lookup of an
+// uncached URL. A Set of the key forgets it at once. Values stored in a tier
+// directly, not through the cache, may stay hidden for up to ttl.
+func WithNegativeCache(ttl time.Duration, maxKeys int) Option {
+	return func(c *config) {
+		if ttl > 0 && maxKeys > 0 {
+			c.absentTTL, c.absentKeys = ttl, maxKeys
+		}
+	}
+}
+
+// NewMultiTier returns a cache over tiers, fastest first. Every tier must be
+// non-nil and a distinct instance, and at least one must not be
+// WriteAround, or nothing would ever be stored. opts tune the write-back
+// queue, if any tier uses one.
+func NewMultiTier(tiers []Tier, opts ...Option) (*MultiTier, error) {
+	cfg := config{workers: DefaultWriteBackWorkers, queue: DefaultWriteBackQueue}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	return newMultiTier(tiers, cfg)
+}
+
+func newMultiTier(tiers []Tier, cfg config) (*MultiTier, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("DoubleCache: no tiers")
+	}
+	stores := false
+	stageAbove := -1
+	for i, tier := range tiers {
+		if nilcache.IsNil(tier.Cache) {
+			return nil, fmt.Errorf("DoubleCache: tier %d is nil", i)
+		}
+		for j := range i {
+			if sameInstance(tiers[j].Cache, tier.Cache) {
+				return nil, fmt.Errorf("DoubleCache: tiers %d and %d are the same instance", j, i)
+			}
+		}
+		switch tier.Write {
+		case WriteThrough:
+			stores = true
+		case WriteBack:
+			stores = true
+			if stageAbove < 0 {
+				stageAbove = i
+			}
+		case WriteAround:
+		default:
+			return nil, fmt.Errorf("DoubleCache: tier %d has unknown write policy %d", i, tier.Write)
+		}
+	}
+	if !stores {
+		return nil, errors.New("DoubleCache: every tier is write-around, so nothing would be stored")
+	}
+	if cfg.onFull != BlockWhenFull && cfg.onFull != DropWhenFull {
+		return nil, fmt.Errorf("DoubleCache: unknown full policy %d", cfg.onFull)
+	}
+	m := &MultiTier{
+		tiers:      append([]Tier(nil), tiers...),
+		stageAbove: stageAbove,
+		writes:     newWriteGuard(),
+	}
+	if cfg.absentTTL > 0 {
+		m.absent = newNegativeCache(cfg.absentTTL, cfg.absentKeys)
+	}
+	if stageAbove >= 0 {
+		m.queue = newWriteQueue(cfg.workers, cfg.queue, cfg.onFull, m.writes.end)
+	}
+	return m, nil
+}
+
+// sameInstance reports whether a and b are the same cache. Comparing the
+// interfaces directly would panic for an uncomparable dynamic type, such as
+// a map-based Cache.
+func sameInstance(a, b httpcache.Cache) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	}
+	return va.Comparable() && va.Equal(vb)
+}
+
+// Get returns the value from the fastest tier holding key, promoting it into
+// the faster tiers that have Promote set.
+func (m *MultiTier) Get(key string) ([]byte, bool) {
+	if m.absent != nil && m.absent.has(key) {
+		return nil, false
+	}
+	gen := m.writes.generation(key)
+	for i, tier := range m.tiers {
+		value, ok := tier.Cache.Get(key)
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			m.writes.ifUnchanged(key, gen, func() {
+				for _, faster := range m.tiers[:i] {
+					if faster.Promote {
+						faster.Cache.Set(key, value)
+					}
+				}
+			})
+		}
+		return value, true
+	}
+	if m.absent != nil {
+		m.writes.ifUnchanged(key, gen, func() { m.absent.add(key) })
+	}
+	return nil, false
+}
+
+// Set stores value in every tier according to its write policy, slowest
+// first.
+func (m *MultiTier) Set(key string, value []byte) {
+	m.writes.begin(key)
+	defer m.writes.end(key)
+	if m.absent != nil {
+		m.absent.forget(key)
+	}
+	for i := len(m.tiers) - 1; i >= 0; i-- {
+		tier := m.tiers[i]
+		switch tier.Write {
+		case WriteThrough:
+			tier.Cache.Set(key, value)
+		case WriteBack:
+			m.writes.begin(key) // ended by the queue once applied
+			m.queue.set(i, tier.Cache, key, value)
+		case WriteAround:
+			if tier.Promote && i < m.stageAbove {
+				tier.Cache.Set(key, value)
+			} else {
+				tier.Cache.Delete(key)
+			}
+		}
+	}
+}
+
+// Delete removes key from every tier, slowest first. For a write-back tier
+// the key is removed at once and again once any write still queued for it
+// has been applied.
+func (m *MultiTier) Delete(key string) {
+	m.writes.begin(key)
+	defer m.writes.end(key)
+	for i := len(m.tiers) - 1; i >= 0; i-- {
+		tier := m.tiers[i]
+		tier.Cache.Delete(key)
+		if tier.Write == WriteBack {
+			m.writes.begin(key)
+			m.queue.delete(i, tier.Cache, key)
+		}
+	}
+}
+
+// Flush blocks until every write queued for a write-back tier before the
+// call has been applied.
+func (m *MultiTier) Flush() {
+	if m.queue != nil {
+		m.queue.flush()
+	}
+}
+
+// DroppedWrites returns how many writes DropWhenFull has turned into
+// deletes.
+func (m *MultiTier) DroppedWrites() int64 {
+	if m.queue == nil {
+		return 0
+	}
+	return m.queue.dropped.Load()
+}
+
+// Close flushes queued writes and stops the write-back workers. Writes after
+// Close are applied synchronously. It always returns nil.
+func (m *MultiTier) Close() error {
+	if m.queue != nil {
+		m.queue.close()
+	}
+	return nil
+}
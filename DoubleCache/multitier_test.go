@@ -0,0 +1,192 @@
+package DoubleCache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/httpcache/LruCache"
+)
+
+// slowCache delays every Set, standing in for a remote tier.
+type slowCache struct {
+	*mapCache
+	delay time.Duration
+}
+
+func (c *slowCache) Set(k string, v []byte) {
+	time.Sleep(c.delay)
+	c.mapCache.Set(k, v)
+}
+
+// funcCache is an uncomparable Cache: comparing two of them with == panics.
+type funcCache map[string][]byte
+
+func (c funcCache) Get(k string) ([]byte, bool) { v, ok := c[k]; return v, ok }
+func (c funcCache) Set(k string, v []byte)      { c[k] = v }
+func (c funcCache) Delete(k string)             { delete(c, k) }
+
+func TestMultiTierPromotesOnlyWhereAsked(t *testing.T) {
+	t1, t2, t3 := newMapCache(), newMapCache(), newMapCache()
+	m, err := NewMultiTier([]Tier{
+		{Cache: t1, Write: WriteAround, Promote: true},
+		{Cache: t2, Write: WriteAround},
+		{Cache: t3, Write: WriteThrough},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Set("k", []byte("v"))
+	if v, ok := m.Get("k"); !ok || string(v) != "v" {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+	if _, ok := t1.Get("k"); !ok {
+		t.Error("value was not promoted into the tier asking for it")
+	}
+	if _, ok := t2.Get("k"); ok {
+		t.Error("value was promoted into a tier without Promote")
+	}
+}
+
+func TestMultiTierWritePolicies(t *testing.T) {
+	through, back, around := newMapCache(), newMapCache(), newMapCache()
+	around.Set("k", []byte("old"))
+	m, err := NewMultiTier([]Tier{
+		{Cache: around, Write: WriteAround},
+		{Cache: through, Write: WriteThrough},
+		{Cache: back, Write: WriteBack},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	m.Set("k", []byte("new"))
+	if _, ok := around.Get("k"); ok {
+		t.Error("write-around tier kept the superseded value")
+	}
+	if v, _ := through.Get("k"); string(v) != "new" {
+		t.Errorf("write-through tier = %q, want \"new\"", v)
+	}
+	m.Flush()
+	if v, _ := back.Get("k"); string(v) != "new" {
+		t.Errorf("write-back tier after Flush = %q, want \"new\"", v)
+	}
+}
+
+// Write-back keeps the slow tier's latency off the caller.
+func TestMultiTierWriteBackDoesNotBlock(t *testing.T) {
+	slow := &slowCache{mapCache: newMapCache(), delay: 200 * time.Millisecond}
+	m, _ := NewMultiTier([]Tier{
+		{Cache: LruCache.NewLRUCache(1 << 20), Write: WriteThrough},
+		{Cache: slow, Write: WriteBack},
+	})
+	defer m.Close()
+
+	start := time.Now()
+	m.Set("k", []byte("v"))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Set took %v with a write-back slow tier", elapsed)
+	}
+	if v, ok := m.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("Get before the write-back landed = %q, %v", v, ok)
+	}
+	m.Flush()
+	if _, ok := slow.Get("k"); !ok {
+		t.Error("Flush returned before the queued write was applied")
+	}
+}
+
+// Queued writes to one key land in order, and a Delete is not undone by a
+// Set queued before it.
+func TestMultiTierWriteBackOrdering(t *testing.T) {
+	back := newMapCache()
+	m, _ := NewMultiTier([]Tier{{Cache: back, Write: WriteBack}})
+	defer m.Close()
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint("k", i%10)
+		m.Set(key, []byte(fmt.Sprint(i)))
+	}
+	m.Set("gone", []byte("v"))
+	m.Delete("gone")
+	m.Flush()
+
+	for i := 90; i < 100; i++ {
+		if v, _ := back.Get(fmt.Sprint("k", i%10)); string(v) != fmt.Sprint(i) {
+			t.Errorf("k%d = %q, want the last write %d", i%10, v, i)
+		}
+	}
+	if _, ok := back.Get("gone"); ok {
+		t.Error("a deleted key was resurrected by its queued Set")
+	}
+}
+
+func TestMultiTierCloseFlushesAndGoesSynchronous(t *testing.T) {
+	slow := &slowCache{mapCache: newMapCache(), delay: 10 * time.Millisecond}
+	m, _ := NewMultiTier([]Tier{{Cache: slow, Write: WriteBack}})
+	for i := 0; i < 20; i++ {
+		m.Set(fmt.Sprint("k", i), []byte("v"))
+	}
+	m.Close()
+	if n := len(slow.m); n != 20 {
+		t.Errorf("slow tier holds %d entries after Close, want 20", n)
+	}
+	m.Set("late", []byte("v"))
+	if _, ok := slow.Get("late"); !ok {
+		t.Error("a write after Close was not applied")
+	}
+	m.Close() // must not panic
+}
+
+func TestMultiTierConcurrentUse(t *testing.T) {
+	m, _ := NewMultiTier([]Tier{
+		{Cache: LruCache.NewLRUCache(1 << 10), Write: WriteAround, Promote: true},
+		{Cache: newMapCache(), Write: WriteBack},
+	})
+	defer m.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprint("k", i%20)
+				m.Set(key, []byte(key))
+				if v, ok := m.Get(key); ok && string(v) != key {
+					t.Errorf("%s = %q", key, v)
+				}
+				if i%7 == 0 {
+					m.Delete(key)
+				}
+				if i%100 == 0 {
+					m.Flush()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNewMultiTierRejectsBadTiers(t *testing.T) {
+	a, b := newMapCache(), newMapCache()
+	fc := funcCache{}
+	cases := map[string][]Tier{
+		"no tiers":         nil,
+		"nil tier":         {{Cache: a}, {Cache: nil}},
+		"typed-nil tier":   {{Cache: a}, {Cache: LruCache.NewLRUCache(0)}},
+		"same instance":    {{Cache: a}, {Cache: b}, {Cache: a}},
+		"same map":         {{Cache: fc}, {Cache: fc}},
+		"all write-around": {{Cache: a, Write: WriteAround}, {Cache: b, Write: WriteAround}},
+		"unknown policy":   {{Cache: a, Write: WritePolicy(42)}},
+	}
+	for name, tiers := range cases {
+		if _, err := NewMultiTier(tiers); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := NewMultiTier([]Tier{{Cache: funcCache{}}, {Cache: funcCache{}}}); err != nil {
+		t.Errorf("distinct uncomparable tiers rejected: %v", err)
+	}
+}
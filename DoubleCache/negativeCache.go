@@ -0,0 +1,80 @@
+package DoubleCache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// negativeCache remembers keys that every tier missed, for a short while,
+// so repeated lookups of them skip the tiers altogether. MultiTier's
+// writeGuard keeps a miss that overlapped a write from being recorded.
+type negativeCache struct {
+	ttl     time.Duration
+	maxKeys int
+	now     func() time.Time
+
+	mu     sync.Mutex
+	order  *list.List // of *absentKey; front is the oldest
+	absent map[string]*list.Element
+}
+
+type absentKey struct {
+	key       string
+	expiresAt time.Time
+}
+
+func newNegativeCache(ttl time.Duration, maxKeys int) *negativeCache {
+	return &negativeCache{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		now:     time.Now,
+		order:   list.New(),
+		absent:  make(map[string]*list.Element),
+	}
+}
+
+// has reports whether key is known to be absent from every tier.
+func (n *negativeCache) has(key string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	el, ok := n.absent[key]
+	if !ok {
+		return false
+	}
+	if n.now().Before(el.Value.(*absentKey).expiresAt) {
+		return true
+	}
+	n.removeLocked(el)
+	return false
+}
+
+// add records key as absent, dropping the oldest keys to stay within
+// maxKeys.
+func (n *negativeCache) add(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	expiresAt := n.now().Add(n.ttl)
+	if el, ok := n.absent[key]; ok {
+		el.Value.(*absentKey).expiresAt = expiresAt
+		n.order.MoveToBack(el)
+		return
+	}
+	for n.order.Len() >= n.maxKeys {
+		n.removeLocked(n.order.Front())
+	}
+	n.absent[key] = n.order.PushBack(&absentKey{key: key, expiresAt: expiresAt})
+}
+
+func (n *negativeCache) forget(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if el, ok := n.absent[key]; ok {
+		n.removeLocked(el)
+	}
+}
+
+func (n *negativeCache) removeLocked(el *list.Element) {
+	n.order.Remove(el)
+	delete(n.absent, el.Value.(*absentKey).key)
+}
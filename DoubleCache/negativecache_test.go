@@ -0,0 +1,122 @@
+package DoubleCache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingCache counts the lookups that reach it, and runs onGet, if set,
+// before answering one.
+type countingCache struct {
+	*mapCache
+	gets  atomic.Int64
+	onGet func()
+}
+
+func (c *countingCache) Get(k string) ([]byte, bool) {
+	c.gets.Add(1)
+	if c.onGet != nil {
+		c.onGet()
+	}
+	return c.mapCache.Get(k)
+}
+
+func newNegativePair(t *testing.T, ttl time.Duration, maxKeys int) (*countingCache, *DoubleCache) {
+	t.Helper()
+	second := &countingCache{mapCache: newMapCache()}
+	dc, err := NewDoubleCache(newMapCache(), second, WithNegativeCache(ttl, maxKeys))
+	if err != nil {
+		t.Fatalf("NewDoubleCache: %v", err)
+	}
+	return second, dc
+}
+
+func TestNegativeCacheSkipsKnownMisses(t *testing.T) {
+	second, dc := newNegativePair(t, time.Minute, 10)
+	for i := 0; i < 5; i++ {
+		if _, ok := dc.Get("missing"); ok {
+			t.Fatal("Get of a missing key reported a hit")
+		}
+	}
+	if n := second.gets.Load(); n != 1 {
+		t.Errorf("second tier saw %d lookups, want 1", n)
+	}
+
+	dc.Set("missing", []byte("v"))
+	if v, ok := dc.Get("missing"); !ok || string(v) != "v" {
+		t.Errorf("Get after Set = %q, %v, want the new value", v, ok)
+	}
+}
+
+func TestNegativeCacheExpires(t *testing.T) {
+	second, dc := newNegativePair(t, time.Minute, 10)
+	now := time.Unix(0, 0)
+	dc.absent.now = func() time.Time { return now }
+
+	dc.Get("k")
+	second.mapCache.Set("k", []byte("v")) // behind the cache's back
+	if _, ok := dc.Get("k"); ok {
+		t.Fatal("the miss was not remembered")
+	}
+	now = now.Add(time.Minute)
+	if v, ok := dc.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("Get once the miss expired = %q, %v, want the value", v, ok)
+	}
+}
+
+func TestNegativeCacheIsBounded(t *testing.T) {
+	second, dc := newNegativePair(t, time.Minute, 3)
+	for i := 0; i < 10; i++ {
+		dc.Get(fmt.Sprint("k", i))
+	}
+	if n := len(dc.absent.absent); n != 3 {
+		t.Errorf("%d keys remembered, want 3", n)
+	}
+	before := second.gets.Load()
+	dc.Get("k0")
+	dc.Get("k9")
+	if n := second.gets.Load() - before; n != 1 {
+		t.Errorf("%d lookups reached the second tier, want 1 for the forgotten oldest key", n)
+	}
+}
+
+// A miss that raced with a Set of the same key must not be remembered, or
+// the new value would stay hidden until it expired.
+func TestNegativeCacheIgnoresMissOverlappingSet(t *testing.T) {
+	second, dc := newNegativePair(t, time.Minute, 10)
+	second.onGet = func() {
+		second.onGet = nil
+		dc.Set("k", []byte("v"))
+		second.mapCache.Delete("k") // the lookup read before the write landed
+	}
+	if _, ok := dc.Get("k"); ok {
+		t.Fatal("the racing lookup saw the write")
+	}
+	second.mapCache.Set("k", []byte("v"))
+	if v, ok := dc.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("Get after the racing Set = %q, %v, want the value", v, ok)
+	}
+}
+
+func TestNegativeCacheForgetsWriteBack(t *testing.T) {
+	first := newMapCache()
+	second := &gatedCache{mapCache: newMapCache(), gate: make(chan struct{})}
+	dc, err := NewDoubleCache(first, second, WithWriteBehind(), WithNegativeCache(time.Minute, 10))
+	if err != nil {
+		t.Fatalf("NewDoubleCache: %v", err)
+	}
+	defer dc.Close()
+
+	dc.Set("k", []byte("v"))
+	first.Delete("k") // the staged copy is evicted before the write lands
+	if _, ok := dc.Get("k"); ok {
+		t.Fatal("Get found the value before the write-back landed")
+	}
+	close(second.gate)
+	dc.Flush()
+	if v, ok := dc.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("Get after the write-back landed = %q, %v, want the value", v, ok)
+	}
+}
@@ -0,0 +1,77 @@
+package DoubleCache
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// guardStripes is how many stripes a writeGuard is split into, to keep
+// unrelated keys from contending.
+const guardStripes = 64
+
+// writeGuard keeps a Get from acting on what it read once a write to the
+// same key has overlapped it. Without it, a Get that read the old value from
+// a slow tier could promote it into a fast tier just after a concurrent Set
+// had invalidated that tier, and the fast tier would serve the superseded
+// value until evicted.
+//
+// Each stripe counts the writes in flight and bumps a generation as each
+// starts and ends. A Get takes the generation before its first read and acts
+// only if, under the stripe lock, the generation is unchanged and no write
+// is in flight. A write that starts later bumps the generation under that
+// same lock, so it either stops the Get or follows the Get's action, and
+// then overwrites or invalidates whatever the Get stored.
+type writeGuard struct {
+	seed    maphash.Seed
+	stripes [guardStripes]writeStripe
+}
+
+type writeStripe struct {
+	mu      sync.Mutex
+	gen     uint64
+	writers int
+}
+
+func newWriteGuard() *writeGuard {
+	return &writeGuard{seed: maphash.MakeSeed()}
+}
+
+func (g *writeGuard) stripe(key string) *writeStripe {
+	return &g.stripes[maphash.String(g.seed, key)%guardStripes]
+}
+
+// generation returns the token a Get of key later passes to ifUnchanged.
+func (g *writeGuard) generation(key string) uint64 {
+	s := g.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gen
+}
+
+// ifUnchanged runs fn, holding the stripe lock, if no write to key's stripe
+// has started or been in flight since gen was taken.
+func (g *writeGuard) ifUnchanged(key string, gen uint64, fn func()) {
+	s := g.stripe(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writers == 0 && s.gen == gen {
+		fn()
+	}
+}
+
+// begin marks a write to key as in flight until the matching end.
+func (g *writeGuard) begin(key string) {
+	s := g.stripe(key)
+	s.mu.Lock()
+	s.writers++
+	s.gen++
+	s.mu.Unlock()
+}
+
+func (g *writeGuard) end(key string) {
+	s := g.stripe(key)
+	s.mu.Lock()
+	s.writers--
+	s.gen++
+	s.mu.Unlock()
+}
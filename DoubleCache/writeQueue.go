@@ -0,0 +1,282 @@
+package DoubleCache
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+const (
+	// DefaultWriteBackWorkers is the number of workers applying queued writes
+	// when WithWriteBackWorkers is not given.
+	DefaultWriteBackWorkers = 4
+	// DefaultWriteBackQueue is the number of writes that may wait in the
+	// queue when WithWriteBackQueue is not given.
+	DefaultWriteBackQueue = 1024
+)
+
+// FullPolicy says what a write does when the write-back queue is full.
+type FullPolicy int
+
+const (
+	// BlockWhenFull makes the writer wait for room, which passes the slow
+	// tier's latency back to callers once it falls behind.
+	BlockWhenFull FullPolicy = iota
+	// DropWhenFull gives up on the write without waiting. So that the tier
+	// cannot go on serving the value it would have replaced, the key is
+	// deleted from it instead, as soon as a worker is free.
+	DropWhenFull
+)
+
+// slot identifies one key in one tier.
+type slot struct {
+	tier int
+	key  string
+}
+
+// writeOp is one queued write to a write-back tier.
+type writeOp struct {
+	slot
+	cache  httpcache.Cache
+	value  []byte
+	delete bool
+	seq    uint64
+	// dropped counts the ops a tombstone stands for.
+	dropped int
+}
+
+// writeQueue applies writes to write-back tiers in the background. Each key
+// hashes to one lane with one worker, so writes to a key are applied in the
+// order they were queued, while different keys proceed in parallel.
+//
+// Only the last write to a slot matters, so a queued op that a newer one has
+// superseded is skipped. That is also how a dropped write takes effect: it
+// supersedes everything queued before it and leaves a tombstone, a delete
+// the worker applies ahead of anything queued after it.
+type writeQueue struct {
+	seed    maphash.Seed
+	lanes   []*lane
+	onFull  FullPolicy
+	dropped atomic.Int64
+	// done, if set, is called with the key of each queued op once it has
+	// been applied, skipped as superseded, or covered by a tombstone.
+	done func(key string)
+
+	// closeMu is held for reading while sending, so close cannot close a
+	// lane under a sender.
+	closeMu sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+
+	// mu guards the lanes' bookkeeping, and cond signals each applied op.
+	mu   sync.Mutex
+	cond sync.Cond
+}
+
+type lane struct {
+	ops  chan writeOp
+	wake chan struct{} // tells an idle worker about new tombstones
+	// sendMu keeps numbering an op and sending it one step, so sequence
+	// numbers follow channel order.
+	sendMu     sync.Mutex
+	seq        uint64
+	queued     uint64 // ops and tombstones ever issued
+	applied    uint64 // ops and tombstones ever applied or skipped
+	pending    map[slot]*pendingSlot
+	tombstones map[slot]*writeOp
+}
+
+// pendingSlot counts a slot's ops still in the lane, and records the newest
+// sequence number issued for it.
+type pendingSlot struct {
+	count  int
+	latest uint64
+}
+
+func newWriteQueue(workers, capacity int, onFull FullPolicy, done func(key string)) *writeQueue {
+	q := &writeQueue{
+		seed:   maphash.MakeSeed(),
+		lanes:  make([]*lane, workers),
+		onFull: onFull,
+		done:   done,
+	}
+	q.cond.L = &q.mu
+	for i := range q.lanes {
+		q.lanes[i] = &lane{
+			ops:        make(chan writeOp, max(capacity/workers, 1)),
+			wake:       make(chan struct{}, 1),
+			pending:    make(map[slot]*pendingSlot),
+			tombstones: make(map[slot]*writeOp),
+		}
+		q.workers.Add(1)
+		go q.work(q.lanes[i])
+	}
+	return q
+}
+
+func (q *writeQueue) work(l *lane) {
+	defer q.workers.Done()
+	for {
+		select {
+		case op, ok := <-l.ops:
+			q.buryTombstones(l)
+			if !ok {
+				return
+			}
+			q.mu.Lock()
+			p := l.pending[op.slot]
+			superseded := op.seq < p.latest
+			if p.count--; p.count == 0 {
+				delete(l.pending, op.slot)
+			}
+			q.mu.Unlock()
+			if !superseded {
+				apply(op)
+			}
+			q.finish(op.key, 1)
+			q.applied(l, 1)
+		case <-l.wake:
+			q.buryTombstones(l)
+		}
+	}
+}
+
+// buryTombstones applies the lane's outstanding tombstones.
+func (q *writeQueue) buryTombstones(l *lane) {
+	q.mu.Lock()
+	if len(l.tombstones) == 0 {
+		q.mu.Unlock()
+		return
+	}
+	tombstones := l.tombstones
+	l.tombstones = make(map[slot]*writeOp)
+	q.mu.Unlock()
+	for _, op := range tombstones {
+		apply(*op)
+		q.finish(op.key, op.dropped)
+	}
+	q.applied(l, len(tombstones))
+}
+
+func (q *writeQueue) applied(l *lane, n int) {
+	q.mu.Lock()
+	l.applied += uint64(n)
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+func (q *writeQueue) finish(key string, ops int) {
+	if q.done != nil {
+		for range ops {
+			q.done(key)
+		}
+	}
+}
+
+func apply(op writeOp) {
+	if op.delete {
+		op.cache.Delete(op.key)
+	} else {
+		op.cache.Set(op.key, op.value)
+	}
+}
+
+func (q *writeQueue) set(tier int, c httpcache.Cache, key string, value []byte) {
+	q.enqueue(writeOp{slot: slot{tier, key}, cache: c, value: value})
+}
+
+func (q *writeQueue) delete(tier int, c httpcache.Cache, key string) {
+	q.enqueue(writeOp{slot: slot{tier, key}, cache: c, delete: true})
+}
+
+// enqueue queues op. If its lane is full it waits for room or, under
+// DropWhenFull, turns op into a tombstone. Once the queue is closed, op is
+// applied on the caller's goroutine.
+func (q *writeQueue) enqueue(op writeOp) {
+	q.closeMu.RLock()
+	defer q.closeMu.RUnlock()
+	if q.closed {
+		apply(op)
+		q.finish(op.key, 1)
+		return
+	}
+	l := q.lanes[maphash.String(q.seed, op.key)%uint64(len(q.lanes))]
+	l.sendMu.Lock()
+	defer l.sendMu.Unlock()
+	l.seq++
+	op.seq = l.seq
+
+	if q.onFull == BlockWhenFull {
+		q.mu.Lock()
+		q.noteQueuedLocked(l, op)
+		q.mu.Unlock()
+		l.ops <- op
+		return
+	}
+
+	// Holding mu across the non-blocking send keeps the worker from looking
+	// op up before it is noted.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case l.ops <- op:
+		q.noteQueuedLocked(l, op)
+		return
+	default:
+	}
+	q.dropped.Add(1)
+	if p, ok := l.pending[op.slot]; ok {
+		p.latest = op.seq
+	}
+	if t, ok := l.tombstones[op.slot]; ok {
+		t.seq = op.seq
+		t.dropped++
+	} else {
+		l.queued++
+		l.tombstones[op.slot] = &writeOp{slot: op.slot, cache: op.cache, delete: true, seq: op.seq, dropped: 1}
+	}
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *writeQueue) noteQueuedLocked(l *lane, op writeOp) {
+	l.queued++
+	p, ok := l.pending[op.slot]
+	if !ok {
+		p = &pendingSlot{}
+		l.pending[op.slot] = p
+	}
+	p.count++
+	p.latest = op.seq
+}
+
+// flush waits until every op queued before the call has been applied.
+func (q *writeQueue) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	targets := make([]uint64, len(q.lanes))
+	for i, l := range q.lanes {
+		targets[i] = l.queued
+	}
+	for i, l := range q.lanes {
+		for l.applied < targets[i] {
+			q.cond.Wait()
+		}
+	}
+}
+
+func (q *writeQueue) close() {
+	q.closeMu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, l := range q.lanes {
+			close(l.ops)
+		}
+	}
+	q.closeMu.Unlock()
+	q.workers.Wait()
+}
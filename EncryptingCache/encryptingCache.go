@@ -0,0 +1,153 @@
+// Package EncryptingCache provides an httpcache.Cache wrapper that encrypts
+// stored responses with AES-GCM, for backends that persist them — a disk, a
+// shared Redis — where tokens and personal data would otherwise sit in
+// plaintext.
+package EncryptingCache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/internal/nilcache"
+)
+
+// formatVersion is the first byte of every entry. An entry with any other
+// version is unreadable and treated as a miss.
+const formatVersion = 1
+
+// headerSize is the version byte followed by the big-endian key ID.
+const headerSize = 1 + 4
+
+// KeyRing holds the keys entries may be encrypted under. New entries use the
+// current key; entries written under any other key in the ring stay readable,
+// which is what makes rotation possible: add the new key, make it current,
+// and drop the old one once its entries have expired or been rewritten.
+//
+// A KeyRing is immutable once built and safe for concurrent use.
+type KeyRing struct {
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+}
+
+// NewKeyRing returns a key ring encrypting under keys[current]. Every key
+// must be 16, 24, or 32 bytes, selecting AES-128, AES-192, or AES-256.
+func NewKeyRing(current uint32, keys map[uint32][]byte) (*KeyRing, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("EncryptingCache: current key %d is not in the ring", current)
+	}
+	ring := &KeyRing{current: current, aeads: make(map[uint32]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("EncryptingCache: key %d: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("EncryptingCache: key %d: %w", id, err)
+		}
+		ring.aeads[id] = aead
+	}
+	return ring, nil
+}
+
+// EncryptingCache encrypts values on their way into an underlying cache and
+// decrypts them on the way out.
+//
+// Each entry is sealed with a fresh random nonce, and the cache key is
+// authenticated as additional data: an entry copied to another key, or a
+// value tampered with in the backend, fails to open. An entry that fails to
+// open for any reason — tampering, an unknown or retired key — is deleted
+// and reported as a miss, so the transport simply refetches it.
+//
+// It is safe for concurrent use if the underlying cache is.
+type EncryptingCache struct {
+	cache httpcache.Cache
+	ring  *KeyRing
+}
+
+// NewEncryptingCache returns a cache that stores values in c encrypted under
+// ring.
+func NewEncryptingCache(c httpcache.Cache, ring *KeyRing) (*EncryptingCache, error) {
+	if nilcache.IsNil(c) {
+		return nil, errors.New("EncryptingCache: underlying cache is nil")
+	}
+	if ring == nil {
+		return nil, errors.New("EncryptingCache: nil key ring")
+	}
+	return &EncryptingCache{cache: c, ring: ring}, nil
+}
+
+// Get returns the decrypted value for key.
+func (c *EncryptingCache) Get(key string) ([]byte, bool) {
+	sealed, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	value, err := c.open(key, sealed)
+	if err != nil {
+		c.cache.Delete(key)
+		return nil, false
+	}
+	return value, true
+}
+
+// Set encrypts value under the current key and stores it under key. If the
+// system's random source fails, nothing is stored and any previous value is
+// removed, so no stale response is left behind.
+func (c *EncryptingCache) Set(key string, value []byte) {
+	sealed, err := c.seal(key, value)
+	if err != nil {
+		c.cache.Delete(key)
+		return
+	}
+	c.cache.Set(key, sealed)
+}
+
+// Delete removes key from the underlying cache.
+func (c *EncryptingCache) Delete(key string) {
+	c.cache.Delete(key)
+}
+
+// An entry is laid out as
+//
+//	version (1) | key ID (4) | nonce | ciphertext and tag
+//
+// and the version, key ID, and cache key are authenticated together.
+func (c *EncryptingCache) seal(key string, value []byte) ([]byte, error) {
+	aead := c.ring.aeads[c.ring.current]
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(value)+aead.Overhead())
+	out[0] = formatVersion
+	binary.BigEndian.PutUint32(out[1:headerSize], c.ring.current)
+	nonce := out[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, value, additionalData(out[:headerSize], key)), nil
+}
+
+func (c *EncryptingCache) open(key string, sealed []byte) ([]byte, error) {
+	if len(sealed) < headerSize || sealed[0] != formatVersion {
+		return nil, errors.New("EncryptingCache: unrecognised entry")
+	}
+	aead, ok := c.ring.aeads[binary.BigEndian.Uint32(sealed[1:headerSize])]
+	if !ok {
+		return nil, errors.New("EncryptingCache: entry sealed under an unknown key")
+	}
+	if len(sealed) < headerSize+aead.NonceSize() {
+		return nil, errors.New("EncryptingCache: truncated entry")
+	}
+	nonce := sealed[headerSize : headerSize+aead.NonceSize()]
+	ciphertext := sealed[headerSize+aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData(sealed[:headerSize], key))
+}
+
+func additionalData(header []byte, key string) []byte {
+	ad := make([]byte, 0, len(header)+len(key))
+	ad = append(ad, header...)
+	return append(ad, key...)
+}
@@ -0,0 +1,189 @@
+package EncryptingCache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/LruCache"
+)
+
+// mapCache is a minimal underlying cache for tests.
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func newMapCache() *mapCache { return &mapCache{m: map[string][]byte{}} }
+func (c *mapCache) Get(k string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[k]
+	return v, ok
+}
+func (c *mapCache) Set(k string, v []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[k] = v
+}
+func (c *mapCache) Delete(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, k)
+}
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+)
+
+func mustRing(t *testing.T, current uint32, keys map[uint32][]byte) *KeyRing {
+	t.Helper()
+	ring, err := NewKeyRing(current, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ring
+}
+
+const secret = "HTTP/1.1 200 OK\r\nSet-Cookie: session=s3cr3t\r\n\r\nBearer abcdef"
+
+func TestRoundTripDoesNotStorePlaintext(t *testing.T) {
+	inner := newMapCache()
+	c, err := NewEncryptingCache(inner, mustRing(t, 1, map[uint32][]byte{1: key1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("k", []byte(secret))
+
+	stored, _ := inner.Get("k")
+	if bytes.Contains(stored, []byte("s3cr3t")) || bytes.Contains(stored, []byte("Bearer")) {
+		t.Error("plaintext visible in the underlying cache")
+	}
+	got, ok := c.Get("k")
+	if !ok || string(got) != secret {
+		t.Fatalf("Get = %q, %v; want the original value", got, ok)
+	}
+
+	// A fresh nonce per write: the same value never seals the same way twice.
+	c.Set("k", []byte(secret))
+	if again, _ := inner.Get("k"); bytes.Equal(again, stored) {
+		t.Error("two writes of the same value produced identical ciphertext")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	inner := newMapCache()
+	old, _ := NewEncryptingCache(inner, mustRing(t, 1, map[uint32][]byte{1: key1}))
+	old.Set("old", []byte("written under key 1"))
+
+	rotated, _ := NewEncryptingCache(inner, mustRing(t, 2, map[uint32][]byte{1: key1, 2: key2}))
+	if got, ok := rotated.Get("old"); !ok || string(got) != "written under key 1" {
+		t.Errorf("entry under the previous key: got %q, %v", got, ok)
+	}
+	rotated.Set("new", []byte("written under key 2"))
+	if stored, _ := inner.Get("new"); stored[4] != 2 {
+		t.Errorf("new entry sealed under key %d, want 2", stored[4])
+	}
+
+	// Once key 1 is retired, its entries are misses and are removed.
+	retired, _ := NewEncryptingCache(inner, mustRing(t, 2, map[uint32][]byte{2: key2}))
+	if _, ok := retired.Get("old"); ok {
+		t.Error("entry under a retired key was served")
+	}
+	if _, ok := inner.Get("old"); ok {
+		t.Error("entry under a retired key was not deleted")
+	}
+	if _, ok := retired.Get("new"); !ok {
+		t.Error("entry under the current key was lost")
+	}
+}
+
+// An entry copied under another cache key must not open: otherwise whoever
+// can write to the backend could serve one URL's response for another.
+func TestEntryBoundToItsKey(t *testing.T) {
+	inner := newMapCache()
+	c, _ := NewEncryptingCache(inner, mustRing(t, 1, map[uint32][]byte{1: key1}))
+	c.Set("a", []byte("response for a"))
+
+	stored, _ := inner.Get("a")
+	inner.Set("b", stored)
+	if _, ok := c.Get("b"); ok {
+		t.Error("entry moved to another key was served")
+	}
+}
+
+func TestTamperedEntryIsMissAndDeleted(t *testing.T) {
+	inner := newMapCache()
+	c, _ := NewEncryptingCache(inner, mustRing(t, 1, map[uint32][]byte{1: key1}))
+	for name, mangle := range map[string]func([]byte) []byte{
+		"flipped bit": func(b []byte) []byte { b[len(b)-1] ^= 1; return b },
+		"truncated":   func(b []byte) []byte { return b[:8] },
+		"bad version": func(b []byte) []byte { b[0] = 9; return b },
+		"empty":       func([]byte) []byte { return nil },
+	} {
+		c.Set("k", []byte(secret))
+		stored, _ := inner.Get("k")
+		inner.Set("k", mangle(bytes.Clone(stored)))
+		if _, ok := c.Get("k"); ok {
+			t.Errorf("%s: entry was served", name)
+		}
+		if _, ok := inner.Get("k"); ok {
+			t.Errorf("%s: entry was not deleted", name)
+		}
+	}
+}
+
+func TestBadArgumentsRejected(t *testing.T) {
+	ring := mustRing(t, 1, map[uint32][]byte{1: key1})
+	if _, err := NewEncryptingCache(nil, ring); err == nil {
+		t.Error("expected error for nil cache")
+	}
+	if _, err := NewEncryptingCache(LruCache.NewLRUCache(0), ring); err == nil {
+		t.Error("expected error for typed-nil cache")
+	}
+	if _, err := NewEncryptingCache(newMapCache(), nil); err == nil {
+		t.Error("expected error for nil key ring")
+	}
+	if _, err := NewKeyRing(2, map[uint32][]byte{1: key1}); err == nil {
+		t.Error("expected error for a current key missing from the ring")
+	}
+	if _, err := NewKeyRing(1, map[uint32][]byte{1: []byte("short")}); err == nil {
+		t.Error("expected error for a key of invalid length")
+	}
+}
+
+func TestServesTransport(t *testing.T) {
+	const body = "private payload"
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	c, _ := NewEncryptingCache(LruCache.NewLRUCache(1<<20), mustRing(t, 1, map[uint32][]byte{1: key1}))
+	client := httpcache.NewTransport(c).Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != body {
+			t.Fatalf("request %d: body = %q, want %q", i+1, got, body)
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+}
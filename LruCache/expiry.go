@@ -0,0 +1,165 @@
+package LruCache
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+// The two deadlines an entry can carry, read from its HTTP headers by
+// httpcache.EntryExpiry.
+const (
+	byStale   = iota // the response stops being fresh
+	byDiscard        // the response is past use even under stale-if-error
+)
+
+// WithStaleFirstEviction makes the cache evict entries whose response has
+// gone stale before consulting the eviction policy, oldest staleness first.
+// Expiry is read from each value's headers as it is stored, which costs a
+// header parse per Set; values the transport did not write are left to the
+// policy.
+func WithStaleFirstEviction() Option {
+	return func(l *LruCache) {
+		l.readExpiry = true
+		l.staleFirst = true
+	}
+}
+
+// WithExpirySweep starts a goroutine that, every interval, drops entries
+// past their freshness lifetime plus any stale-if-error window, since the
+// transport can no longer serve them without revalidating, and entries past
+// the WithMaxTTL cap. Close the cache to stop it.
+func WithExpirySweep(interval time.Duration) Option {
+	return func(l *LruCache) {
+		if interval > 0 {
+			l.readExpiry = true
+			l.sweepEvery = interval
+		}
+	}
+}
+
+// Close stops the expiry sweeper, if one is running. The cache remains usable
+// afterwards. It always returns nil.
+func (l *LruCache) Close() error {
+	l.closeOnce.Do(func() {
+		if l.stopSweep != nil {
+			close(l.stopSweep)
+		}
+	})
+	return nil
+}
+
+func (l *LruCache) startSweep() {
+	if l.sweepEvery > 0 {
+		l.stopSweep = make(chan struct{})
+		go l.sweepLoop()
+	}
+}
+
+func (l *LruCache) sweepLoop() {
+	ticker := time.NewTicker(l.sweepEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stopSweep:
+			return
+		case <-ticker.C:
+			l.sweep()
+		}
+	}
+}
+
+// sweep drops every entry past its discard deadline.
+func (l *LruCache) sweep() {
+	l.mu.Lock()
+	defer l.unlock()
+	now := l.now()
+	h := &l.expiry[byDiscard]
+	for h.Len() > 0 && !h.items[0].deadline[byDiscard].After(now) {
+		l.removeLocked(h.items[0].key, EvictExpired)
+	}
+}
+
+// expiredVictimLocked returns an entry past its discard deadline if there is
+// one, and otherwise, with WithStaleFirstEviction, the entry that went stale
+// longest ago. The reason is the one to report for evicting it.
+func (l *LruCache) expiredVictimLocked() (string, EvictReason, bool) {
+	now := l.now()
+	if h := &l.expiry[byDiscard]; h.Len() > 0 && !h.items[0].deadline[byDiscard].After(now) {
+		return h.items[0].key, EvictExpired, true
+	}
+	if h := &l.expiry[byStale]; l.staleFirst && h.Len() > 0 && !h.items[0].deadline[byStale].After(now) {
+		return h.items[0].key, EvictCapacity, true
+	}
+	return "", 0, false
+}
+
+// trackLocked sets e's deadlines for a newly stored value — read from its
+// headers, capped by WithMaxTTL — and files it in the expiry heaps
+// accordingly.
+func (l *LruCache) trackLocked(e *entry) {
+	if !l.readExpiry && l.maxTTL == 0 {
+		return
+	}
+	l.untrackLocked(e)
+	var staleAt, discardAt time.Time
+	var ok bool
+	if l.readExpiry {
+		staleAt, discardAt, ok = httpcache.EntryExpiry(e.value)
+	}
+	if l.maxTTL > 0 {
+		e.expiresAt = l.now().Add(l.maxTTL)
+		if !ok || discardAt.IsZero() || e.expiresAt.Before(discardAt) {
+			discardAt = e.expiresAt
+		}
+	}
+	e.deadline = [2]time.Time{staleAt, discardAt}
+	if ok {
+		heap.Push(&l.expiry[byStale], e)
+	}
+	if !discardAt.IsZero() {
+		heap.Push(&l.expiry[byDiscard], e)
+	}
+}
+
+func (l *LruCache) untrackLocked(e *entry) {
+	for which := range l.expiry {
+		if i := e.heapIdx[which]; i >= 0 {
+			heap.Remove(&l.expiry[which], i)
+		}
+	}
+}
+
+// expiryHeap is a min-heap of entries ordered by one of their deadlines.
+// Each entry records its own position so it can be removed in O(log n).
+type expiryHeap struct {
+	which int
+	items []*entry
+}
+
+func (h *expiryHeap) Len() int { return len(h.items) }
+
+func (h *expiryHeap) Less(i, j int) bool {
+	return h.items[i].deadline[h.which].Before(h.items[j].deadline[h.which])
+}
+
+func (h *expiryHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].heapIdx[h.which] = i
+	h.items[j].heapIdx[h.which] = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*entry)
+	e.heapIdx[h.which] = len(h.items)
+	h.items = append(h.items, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	e := h.items[len(h.items)-1]
+	h.items[len(h.items)-1] = nil
+	h.items = h.items[:len(h.items)-1]
+	e.heapIdx[h.which] = -1
+	return e
+}
@@ -0,0 +1,102 @@
+package LruCache
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// dumped builds a stored response dated at epoch. The cache reads expiry
+// from unframed dumps as well as framed entries, which keeps these tests
+// independent of the transport.
+func dumped(cacheControl string) []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nDate: %s\r\nCache-Control: %s\r\nContent-Length: 4\r\n\r\nbody",
+		epoch.Format(http.TimeFormat), cacheControl))
+}
+
+func TestStaleFirstEviction(t *testing.T) {
+	value := dumped("max-age=60")
+	c := NewLRUCache(int64(3*len(value)), WithStaleFirstEviction())
+	c.now = func() time.Time { return epoch.Add(30 * time.Second) }
+
+	c.Set("stale", dumped("max-age=10"))
+	c.Set("fresh1", value)
+	c.Set("fresh2", value)
+	c.Get("stale") // most recently used, but stale
+	c.Set("fresh3", value)
+
+	if _, ok := c.Get("stale"); ok {
+		t.Error("stale entry survived while a fresh one was evicted")
+	}
+	for _, key := range []string{"fresh1", "fresh2", "fresh3"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("fresh entry %s was evicted", key)
+		}
+	}
+}
+
+// Without the option eviction order is the policy's alone.
+func TestStaleIgnoredByDefault(t *testing.T) {
+	value := dumped("max-age=60")
+	c := NewLRUCache(int64(3 * len(value)))
+	c.now = func() time.Time { return epoch.Add(30 * time.Second) }
+
+	c.Set("stale", dumped("max-age=10"))
+	c.Set("fresh1", value)
+	c.Set("fresh2", value)
+	c.Get("stale")
+	c.Set("fresh3", value)
+	if _, ok := c.Get("stale"); !ok {
+		t.Error("recently used entry was evicted")
+	}
+}
+
+func TestSweepDropsOnlyUnusableEntries(t *testing.T) {
+	c := NewLRUCache(1<<20, WithExpirySweep(time.Hour))
+	defer c.Close()
+	c.now = func() time.Time { return epoch.Add(100 * time.Second) }
+
+	c.Set("expired", dumped("max-age=10"))
+	c.Set("stale-if-error", dumped("max-age=10, stale-if-error=300"))
+	c.Set("fresh", dumped("max-age=600"))
+	c.Set("immutable", dumped("max-age=10, immutable"))
+	c.Set("opaque", []byte("not an HTTP response"))
+	c.sweep()
+
+	if _, ok := c.Get("expired"); ok {
+		t.Error("entry past max-age was not swept")
+	}
+	for _, key := range []string{"stale-if-error", "fresh", "immutable", "opaque"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s was swept", key)
+		}
+	}
+
+	// Overwriting an entry refiles it under its new deadline.
+	c.Set("fresh", dumped("max-age=10"))
+	c.sweep()
+	if _, ok := c.Get("fresh"); ok {
+		t.Error("overwritten entry kept its old deadline")
+	}
+	if n := c.expiry[byDiscard].Len(); n != 1 {
+		t.Errorf("discard heap holds %d entries, want 1", n)
+	}
+}
+
+func TestSweeperRunsUntilClosed(t *testing.T) {
+	c := NewLRUCache(1<<20, WithExpirySweep(10*time.Millisecond))
+	c.Set("expired", dumped("max-age=10")) // dated long ago by the real clock
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if c.Len() != 0 {
+		t.Error("sweeper did not drop the expired entry")
+	}
+	c.Close()
+	c.Close() // must not panic
+}
@@ -0,0 +1,38 @@
+package LruCache
+
+import "time"
+
+// EstimatedEntryOverhead approximates the memory one entry costs beyond its
+// key and value on a 64-bit platform: the map slot, the entry itself, and
+// the policy's per-key bookkeeping.
+const EstimatedEntryOverhead = 160
+
+// WithMaxEntries caps the number of entries, evicting as for the byte budget
+// once it is exceeded. Zero or negative means no cap. For a ShardedLruCache
+// the cap is split across the shards.
+func WithMaxEntries(n int) Option {
+	return func(l *LruCache) {
+		l.maxEntries = max(n, 0)
+	}
+}
+
+// WithEntryOverhead counts each entry's key, plus perEntry bytes of
+// bookkeeping, against the byte budget alongside its value. Without it only
+// values are counted, and a cache full of small entries — bodiless 304
+// revalidations, say — can use many times maxBytes of memory.
+// EstimatedEntryOverhead is a reasonable perEntry.
+func WithEntryOverhead(perEntry int64) Option {
+	return func(l *LruCache) {
+		l.overhead = max(perEntry, 0)
+	}
+}
+
+// WithMaxTTL drops every entry d after it was stored, whatever its HTTP
+// headers say. A Get after the deadline is a miss; expired entries are the
+// first to go when room is needed, and with WithExpirySweep they are swept
+// too. Zero or negative means no cap.
+func WithMaxTTL(d time.Duration) Option {
+	return func(l *LruCache) {
+		l.maxTTL = max(d, 0)
+	}
+}
@@ -0,0 +1,136 @@
+package LruCache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMaxEntries(t *testing.T) {
+	c := NewLRUCache(1<<20, WithMaxEntries(3))
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint("k", i), nil) // a bodiless entry costs no bytes
+	}
+	if c.Len() != 3 {
+		t.Errorf("Len = %d, want 3", c.Len())
+	}
+	if _, ok := c.Get("k9"); !ok {
+		t.Error("newest entry was evicted")
+	}
+	if _, ok := c.Get("k0"); ok {
+		t.Error("oldest entry survived the cap")
+	}
+}
+
+func TestEntryOverheadCountsAgainstBudget(t *testing.T) {
+	c := NewLRUCache(1000, WithEntryOverhead(100))
+	c.Set("key", []byte("value"))
+	if want := int64(len("key") + len("value") + 100); c.Size() != want {
+		t.Errorf("Size = %d, want %d", c.Size(), want)
+	}
+	c.Set("key", []byte("v"))
+	if want := int64(len("key") + len("v") + 100); c.Size() != want {
+		t.Errorf("Size after overwrite = %d, want %d", c.Size(), want)
+	}
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("k", i), nil)
+	}
+	if c.Size() > 1000 || c.Len() > 10 {
+		t.Errorf("Size = %d with %d entries; empty values escaped the budget", c.Size(), c.Len())
+	}
+	c.Delete("k99")
+	var sum int64
+	for key, e := range c.items {
+		sum += int64(len(key)+len(e.value)) + 100
+	}
+	if sum != c.Size() {
+		t.Errorf("Size = %d, entries account for %d", c.Size(), sum)
+	}
+
+	// A key is enough to make an entry oversized.
+	before := c.Len()
+	c.Set(string(make([]byte, 1000)), nil)
+	if c.Len() != before {
+		t.Errorf("Len = %d after an oversized key, want %d", c.Len(), before)
+	}
+}
+
+func TestMaxTTLDropsEntriesRegardlessOfHeaders(t *testing.T) {
+	now := epoch
+	c := NewLRUCache(1<<20, WithMaxTTL(time.Minute))
+	c.now = func() time.Time { return now }
+
+	c.Set("long-lived", dumped("max-age=86400"))
+	c.Set("opaque", []byte("not an HTTP response"))
+	now = now.Add(59 * time.Second)
+	if _, ok := c.Get("long-lived"); !ok {
+		t.Fatal("entry dropped before its TTL")
+	}
+	now = now.Add(time.Second)
+	for _, key := range []string{"long-lived", "opaque"} {
+		if _, ok := c.Get(key); ok {
+			t.Errorf("%s served past the TTL cap", key)
+		}
+	}
+	if c.Len() != 0 || c.Size() != 0 {
+		t.Errorf("Len = %d, Size = %d; expired entries not removed", c.Len(), c.Size())
+	}
+}
+
+// An entry past its TTL is evicted before anything the policy would choose.
+func TestMaxTTLExpiredEvictedFirst(t *testing.T) {
+	now := epoch
+	c := NewLRUCache(8, WithMaxTTL(time.Minute))
+	c.now = func() time.Time { return now }
+
+	c.Set("old", []byte("aaaa"))
+	now = now.Add(30 * time.Second)
+	c.Set("new", []byte("bbbb"))
+	c.Get("new")
+	now = now.Add(40 * time.Second) // old has expired, new has not
+	c.Get("new")
+	c.Set("newer", []byte("cccc"))
+	if _, ok := c.Get("new"); !ok {
+		t.Error("a live entry was evicted while an expired one was held")
+	}
+}
+
+func TestMaxTTLSwept(t *testing.T) {
+	now := epoch
+	c := NewLRUCache(1<<20, WithMaxTTL(time.Minute), WithExpirySweep(time.Hour))
+	defer c.Close()
+	c.now = func() time.Time { return now }
+
+	c.Set("capped", dumped("max-age=86400, stale-if-error"))
+	c.Set("short", dumped("max-age=10"))
+	now = now.Add(30 * time.Second)
+	c.sweep()
+	if _, ok := c.items["short"]; ok {
+		t.Error("entry past its own max-age was not swept")
+	}
+	if _, ok := c.items["capped"]; !ok {
+		t.Error("entry swept before its TTL")
+	}
+	now = now.Add(31 * time.Second)
+	c.sweep()
+	if c.Len() != 0 {
+		t.Error("entry past the TTL cap was not swept")
+	}
+}
+
+func TestShardedSplitsEntryCap(t *testing.T) {
+	c := NewShardedLRUCache(1<<20, 4, WithMaxEntries(10))
+	total := 0
+	for _, sh := range c.shards {
+		total += sh.maxEntries
+	}
+	if total != 10 {
+		t.Errorf("shard entry caps sum to %d, want 10", total)
+	}
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("k", i), nil)
+	}
+	if c.Len() > 10 {
+		t.Errorf("Len = %d, exceeds the cap of 10", c.Len())
+	}
+}
@@ -3,98 +3,176 @@
 package LruCache
 
 import (
-	"container/list"
+	"strings"
 	"sync"
+	"time"
 )
 
 type entry struct {
 	key   string
 	value []byte
+	size  int64 // what the entry counts against the budget
+	// expiresAt is the hard deadline set by WithMaxTTL, zero without one.
+	expiresAt time.Time
+	// deadline and heapIdx are only maintained when the cache tracks expiry;
+	// heapIdx is -1 while the entry is not in that heap.
+	deadline [2]time.Time
+	heapIdx  [2]int
 }
 
-// LruCache is an in-memory cache that evicts least-recently-used entries once
-// the total size of stored responses would exceed maxBytes.
+func newEntry(key string, value []byte, size int64) *entry {
+	return &entry{key: key, value: value, size: size, heapIdx: [2]int{-1, -1}}
+}
+
+// LruCache is an in-memory cache that evicts entries once the total size of
+// stored responses would exceed maxBytes. Which entries go is up to its
+// Policy; by default the least recently used.
 //
 // It is safe for concurrent use by multiple goroutines.
 type LruCache struct {
-	mu       sync.Mutex
-	maxBytes int64
-	curBytes int64
-	ll       *list.List // front is most recently used
-	items    map[string]*list.Element
+	mu         sync.Mutex
+	maxBytes   int64
+	curBytes   int64
+	maxEntries int
+	overhead   int64 // charged per entry on top of its key; -1 charges neither
+	policy     Policy
+	items      map[string]*entry
+
+	onEvict func(key string, size int64, reason EvictReason)
+	pending []eviction // evictions to report once the lock is released
+	stats   counters
+
+	readExpiry bool
+	maxTTL     time.Duration
+	staleFirst bool
+	expiry     [2]expiryHeap // indexed by byStale and byDiscard
+	now        func() time.Time
+	sweepEvery time.Duration
+	stopSweep  chan struct{}
+	closeOnce  sync.Once
+}
+
+// Option configures an LruCache.
+type Option func(*LruCache)
+
+// WithPolicy selects the eviction policy. newPolicy is called once per
+// cache, so the same option can configure several caches, e.g. the shards of
+// a ShardedLruCache.
+func WithPolicy(newPolicy func() Policy) Option {
+	return func(l *LruCache) {
+		l.policy = newPolicy()
+	}
 }
 
 // NewLRUCache returns a cache holding at most maxBytes of response data.
 // It returns nil if maxBytes is not positive.
 //
 // maxBytes is a byte budget, not an entry count.
-func NewLRUCache(maxBytes int64) *LruCache {
+func NewLRUCache(maxBytes int64, opts ...Option) *LruCache {
+	l := newLRUCache(maxBytes, opts...)
+	if l != nil {
+		l.startSweep()
+	}
+	return l
+}
+
+// newLRUCache builds the cache without starting its sweeper, so it can be
+// filled before anything else can touch it.
+func newLRUCache(maxBytes int64, opts ...Option) *LruCache {
 	if maxBytes <= 0 {
 		return nil
 	}
-	return &LruCache{
+	l := &LruCache{
 		maxBytes: maxBytes,
-		ll:       list.New(),
-		items:    make(map[string]*list.Element),
+		overhead: -1,
+		items:    make(map[string]*entry),
+		expiry:   [2]expiryHeap{{which: byStale}, {which: byDiscard}},
+		now:      time.Now,
 	}
+	for _, o := range opts {
+		o(l)
+	}
+	if l.policy == nil {
+		l.policy = LRU()
+	}
+	return l
 }
 
 // Get returns the cached response for key and whether it was present.
 // The returned slice must not be modified by the caller.
 func (l *LruCache) Get(key string) (responseBytes []byte, ok bool) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
-	el, ok := l.items[key]
+	defer l.unlock()
+	e, ok := l.items[key]
+	if ok && !e.expiresAt.IsZero() && !l.now().Before(e.expiresAt) {
+		l.removeLocked(key, EvictExpired)
+		ok = false
+	}
 	if !ok {
+		l.stats.misses.Add(1)
 		return nil, false
 	}
-	l.ll.MoveToFront(el)
-	return el.Value.(*entry).value, true
+	l.stats.hits.Add(1)
+	l.policy.Hit(key)
+	return e.value, true
 }
 
-// Set stores responseBytes under key, evicting least-recently-used entries
-// until the total size is within budget. The cache takes ownership of
-// responseBytes, which the caller must not modify afterwards.
+// Set stores responseBytes under key, evicting entries until the cache is
+// within its limits: first any past their deadline, then stale ones if
+// WithStaleFirstEviction is set, then those the policy chooses. The cache
+// takes ownership of responseBytes, which the caller must not modify
+// afterwards.
 //
 // A value larger than the whole budget is not stored, and any previous value
 // for key is removed so no stale response is left behind.
 func (l *LruCache) Set(key string, responseBytes []byte) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
+	defer l.unlock()
+	l.stats.sets.Add(1)
 
-	size := int64(len(responseBytes))
+	size := l.charge(key, responseBytes)
 	if size > l.maxBytes {
-		l.removeLocked(key)
+		l.removeLocked(key, EvictReplaced)
+		l.evictedLocked(key, size, EvictOversized)
 		return
 	}
 
-	if el, ok := l.items[key]; ok {
-		e := el.Value.(*entry)
-		l.curBytes += size - int64(len(e.value))
-		e.value = responseBytes
-		l.ll.MoveToFront(el)
+	if e, ok := l.items[key]; ok {
+		l.evictedLocked(key, e.size, EvictReplaced)
+		l.curBytes += size - e.size
+		e.value, e.size = responseBytes, size
+		l.trackLocked(e)
+		l.policy.Update(key, size)
 	} else {
-		l.items[key] = l.ll.PushFront(&entry{key: key, value: responseBytes})
+		e := newEntry(key, responseBytes, size)
+		l.items[key] = e
 		l.curBytes += size
+		l.trackLocked(e)
+		l.policy.Insert(key, size)
 	}
 
-	for l.curBytes > l.maxBytes {
-		back := l.ll.Back()
-		if back == nil {
+	for l.curBytes > l.maxBytes || (l.maxEntries > 0 && len(l.items) > l.maxEntries) {
+		if victim, reason, ok := l.expiredVictimLocked(); ok {
+			l.removeLocked(victim, reason)
+			continue
+		}
+		victim, ok := l.policy.Evict()
+		if !ok {
 			break
 		}
-		l.removeElementLocked(back)
+		l.dropLocked(victim, EvictCapacity)
 	}
 }
 
 // Delete removes the entry for key, if present.
 func (l *LruCache) Delete(key string) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
-	l.removeLocked(key)
+	defer l.unlock()
+	l.removeLocked(key, EvictDeleted)
 }
 
-// Size returns the total number of bytes currently held.
+// Size returns the total number of bytes currently held, as counted against
+// the budget: with WithEntryOverhead that includes keys and bookkeeping.
 func (l *LruCache) Size() int64 {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -108,15 +186,43 @@ func (l *LruCache) Len() int {
 	return len(l.items)
 }
 
-func (l *LruCache) removeLocked(key string) {
-	if el, ok := l.items[key]; ok {
-		l.removeElementLocked(el)
+// Keys returns the keys currently held that start with prefix, in no
+// particular order.
+func (l *LruCache) Keys(prefix string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var keys []string
+	for key := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// removeLocked removes key from the cache and from the policy.
+func (l *LruCache) removeLocked(key string, reason EvictReason) {
+	if _, ok := l.items[key]; ok {
+		l.policy.Remove(key)
+		l.dropLocked(key, reason)
 	}
 }
 
-func (l *LruCache) removeElementLocked(el *list.Element) {
-	e := el.Value.(*entry)
-	l.ll.Remove(el)
-	delete(l.items, e.key)
-	l.curBytes -= int64(len(e.value))
+// dropLocked removes key from the cache only, for a key the policy has
+// already forgotten.
+func (l *LruCache) dropLocked(key string, reason EvictReason) {
+	if e, ok := l.items[key]; ok {
+		delete(l.items, key)
+		l.curBytes -= e.size
+		l.untrackLocked(e)
+		l.evictedLocked(key, e.size, reason)
+	}
+}
+
+// charge returns what storing value under key counts against the budget.
+func (l *LruCache) charge(key string, value []byte) int64 {
+	if l.overhead < 0 {
+		return int64(len(value))
+	}
+	return int64(len(key)+len(value)) + l.overhead
 }
@@ -2,6 +2,7 @@ package LruCache
 
 import (
 	"fmt"
+	"slices"
 	"sync"
 	"testing"
 )
@@ -93,6 +94,25 @@ func TestDeleteRemovesAndFreesBytes(t *testing.T) {
 	c.Delete("missing") // must not panic
 }
 
+func TestKeysFiltersByPrefix(t *testing.T) {
+	for _, c := range []interface {
+		Set(string, []byte)
+		Keys(string) []string
+	}{NewLRUCache(1 << 20), NewShardedLRUCache(1<<20, 4)} {
+		for _, k := range []string{"a:1", "a:2", "b:1"} {
+			c.Set(k, []byte("v"))
+		}
+		got := c.Keys("a:")
+		slices.Sort(got)
+		if !slices.Equal(got, []string{"a:1", "a:2"}) {
+			t.Errorf("%T: Keys(\"a:\") = %q", c, got)
+		}
+		if n := len(c.Keys("")); n != 3 {
+			t.Errorf("%T: Keys(\"\") returned %d keys, want 3", c, n)
+		}
+	}
+}
+
 func TestNewLRUCacheRejectsNonPositive(t *testing.T) {
 	for _, n := range []int64{0, -1} {
 		if got := NewLRUCache(n); got != nil {
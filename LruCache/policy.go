@@ -0,0 +1,202 @@
+package LruCache
+
+import "container/list"
+
+// Policy decides which entries an LruCache evicts when it is over budget.
+// The cache owns the values and the byte accounting; a policy only tracks
+// keys and sizes, and names a victim when asked.
+//
+// Every method is called with the cache's lock held, so a policy needs no
+// locking of its own and must not call back into the cache. A policy
+// instance belongs to exactly one cache.
+type Policy interface {
+	// Insert records a newly stored key of size bytes.
+	Insert(key string, size int64)
+	// Update records that the value for a tracked key was replaced with one
+	// of size bytes.
+	Update(key string, size int64)
+	// Hit records a Get that found key.
+	Hit(key string)
+	// Remove forgets key, which the cache dropped on its own: a Delete, or
+	// a value too large to store.
+	Remove(key string)
+	// Evict chooses the next entry to evict, forgets it, and returns its
+	// key. It reports false if no key is tracked.
+	Evict() (key string, ok bool)
+}
+
+// LRU returns the default policy, which evicts the least recently used
+// entry. It is cheap and adapts instantly, but a single pass over many
+// one-off keys flushes everything else.
+func LRU() Policy {
+	return &lruPolicy{ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+type lruPolicy struct {
+	ll    *list.List // of keys; front is most recently used
+	items map[string]*list.Element
+}
+
+func (p *lruPolicy) Insert(key string, _ int64) { p.items[key] = p.ll.PushFront(key) }
+func (p *lruPolicy) Update(key string, _ int64) { p.Hit(key) }
+
+func (p *lruPolicy) Hit(key string) {
+	if el, ok := p.items[key]; ok {
+		p.ll.MoveToFront(el)
+	}
+}
+
+func (p *lruPolicy) Remove(key string) {
+	if el, ok := p.items[key]; ok {
+		p.ll.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lruPolicy) Evict() (string, bool) {
+	back := p.ll.Back()
+	if back == nil {
+		return "", false
+	}
+	key := p.ll.Remove(back).(string)
+	delete(p.items, key)
+	return key, true
+}
+
+// S3FIFO returns a scan-resistant policy based on S3-FIFO (Yang et al.,
+// SOSP 2023). New keys enter a small probationary queue holding about a
+// tenth of the bytes; only those hit while on probation are promoted to the
+// main queue, so a burst of one-off requests churns through the small queue
+// without displacing the established working set. Keys evicted from
+// probation are remembered in a ghost queue, and one that comes back soon
+// goes straight to the main queue.
+//
+// A hit only bumps a small counter instead of reordering a list.
+func S3FIFO() Policy {
+	return &s3fifo{
+		small:   list.New(),
+		main:    list.New(),
+		ghost:   list.New(),
+		entries: make(map[string]*s3entry),
+		ghosts:  make(map[string]*list.Element),
+	}
+}
+
+// s3maxFreq caps the access counter, so an entry hammered once long ago
+// survives at most a few passes of the main queue.
+const s3maxFreq = 3
+
+type s3fifo struct {
+	small, main *list.List // of *s3entry; front is newest
+	ghost       *list.List // of keys; front is newest
+	entries     map[string]*s3entry
+	ghosts      map[string]*list.Element
+	smallBytes  int64
+	mainBytes   int64
+}
+
+type s3entry struct {
+	key    string
+	size   int64
+	freq   uint8
+	inMain bool
+	el     *list.Element
+}
+
+func (p *s3fifo) Insert(key string, size int64) {
+	e := &s3entry{key: key, size: size}
+	if el, ok := p.ghosts[key]; ok {
+		p.ghost.Remove(el)
+		delete(p.ghosts, key)
+		p.pushMain(e)
+	} else {
+		e.el = p.small.PushFront(e)
+		p.smallBytes += size
+	}
+	p.entries[key] = e
+}
+
+func (p *s3fifo) Update(key string, size int64) {
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	if e.inMain {
+		p.mainBytes += size - e.size
+	} else {
+		p.smallBytes += size - e.size
+	}
+	e.size = size
+	p.Hit(key)
+}
+
+func (p *s3fifo) Hit(key string) {
+	if e, ok := p.entries[key]; ok && e.freq < s3maxFreq {
+		e.freq++
+	}
+}
+
+func (p *s3fifo) Remove(key string) {
+	e, ok := p.entries[key]
+	if !ok {
+		return
+	}
+	p.unlink(e)
+	delete(p.entries, key)
+}
+
+func (p *s3fifo) Evict() (string, bool) {
+	for {
+		switch {
+		case p.small.Len() > 0 && (p.main.Len() == 0 || p.smallBytes*10 >= p.smallBytes+p.mainBytes):
+			e := p.small.Back().Value.(*s3entry)
+			p.unlink(e)
+			if e.freq > 0 {
+				e.freq = 0
+				p.pushMain(e)
+				continue
+			}
+			delete(p.entries, e.key)
+			p.remember(e.key)
+			return e.key, true
+		case p.main.Len() > 0:
+			e := p.main.Back().Value.(*s3entry)
+			if e.freq > 0 {
+				e.freq--
+				p.main.MoveToFront(e.el)
+				continue
+			}
+			p.unlink(e)
+			delete(p.entries, e.key)
+			return e.key, true
+		default:
+			return "", false
+		}
+	}
+}
+
+func (p *s3fifo) pushMain(e *s3entry) {
+	e.inMain = true
+	e.el = p.main.PushFront(e)
+	p.mainBytes += e.size
+}
+
+func (p *s3fifo) unlink(e *s3entry) {
+	if e.inMain {
+		p.main.Remove(e.el)
+		p.mainBytes -= e.size
+	} else {
+		p.small.Remove(e.el)
+		p.smallBytes -= e.size
+	}
+	e.inMain, e.el = false, nil
+}
+
+// remember adds key to the ghost queue, which holds at most as many keys as
+// the cache holds entries.
+func (p *s3fifo) remember(key string) {
+	p.ghosts[key] = p.ghost.PushFront(key)
+	for p.ghost.Len() > max(len(p.entries), 1) {
+		delete(p.ghosts, p.ghost.Remove(p.ghost.Back()).(string))
+	}
+}
@@ -0,0 +1,133 @@
+package LruCache
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+var policies = map[string]func() Policy{"LRU": LRU, "S3FIFO": S3FIFO}
+
+// The byte budget and the oversized-value rule hold whatever the policy.
+func TestPoliciesKeepBudgetAndOversizeRule(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			c := NewLRUCache(100, WithPolicy(newPolicy))
+			for i := 0; i < 200; i++ {
+				c.Set(fmt.Sprint("k", i), make([]byte, 1+i%17))
+				if c.Size() > 100 {
+					t.Fatalf("after %d sets Size = %d, exceeds budget of 100", i+1, c.Size())
+				}
+			}
+			c.Set("big", []byte("small"))
+			c.Set("big", make([]byte, 101))
+			if _, ok := c.Get("big"); ok {
+				t.Error("oversized value was stored, or stale value was left behind")
+			}
+		})
+	}
+}
+
+// A hot working set survives a scan of one-off keys under S3-FIFO; plain LRU
+// loses it, which is what the policy exists to fix.
+func TestS3FIFOResistsScans(t *testing.T) {
+	const size = 10
+	survivors := func(newPolicy func() Policy) int {
+		c := NewLRUCache(100*size, WithPolicy(newPolicy))
+		for round := 0; round < 3; round++ {
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprint("hot", i)
+				if _, ok := c.Get(key); !ok {
+					c.Set(key, make([]byte, size))
+				}
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			c.Set(fmt.Sprint("scan", i), make([]byte, size))
+		}
+		n := 0
+		for i := 0; i < 50; i++ {
+			if _, ok := c.Get(fmt.Sprint("hot", i)); ok {
+				n++
+			}
+		}
+		return n
+	}
+	if n := survivors(S3FIFO); n < 45 {
+		t.Errorf("S3FIFO kept %d of 50 hot entries through a scan, want nearly all", n)
+	}
+	if n := survivors(LRU); n != 0 {
+		t.Errorf("LRU kept %d of 50 hot entries; the scan should have flushed them", n)
+	}
+}
+
+// A key evicted from probation that comes back soon is admitted straight to
+// the main queue.
+func TestS3FIFOGhostReadmits(t *testing.T) {
+	p := S3FIFO().(*s3fifo)
+	p.Insert("a", 1)
+	p.Insert("b", 1)
+	if key, _ := p.Evict(); key != "a" {
+		t.Fatalf("evicted %q, want the oldest probationary key a", key)
+	}
+	p.Insert("a", 1)
+	if e := p.entries["a"]; !e.inMain {
+		t.Error("a key remembered in the ghost queue was put on probation again")
+	}
+}
+
+// Random operations never let the policy and the cache disagree about which
+// keys are held, or the byte counts drift.
+func TestPoliciesStayInStepWithCache(t *testing.T) {
+	for name, newPolicy := range policies {
+		t.Run(name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			c := NewLRUCache(500, WithPolicy(newPolicy))
+			for i := 0; i < 20000; i++ {
+				key := fmt.Sprint("k", rng.Intn(100))
+				switch rng.Intn(4) {
+				case 0:
+					c.Delete(key)
+				case 1:
+					c.Get(key)
+				default:
+					c.Set(key, make([]byte, rng.Intn(60)))
+				}
+			}
+			var sum int64
+			for _, e := range c.items {
+				sum += int64(len(e.value))
+			}
+			if sum != c.Size() {
+				t.Errorf("Size = %d, but entries hold %d bytes", c.Size(), sum)
+			}
+			// Evicting everything must name each held key exactly once.
+			seen := map[string]bool{}
+			for {
+				key, ok := c.policy.Evict()
+				if !ok {
+					break
+				}
+				if _, held := c.items[key]; !held || seen[key] {
+					t.Fatalf("policy evicted %q, which the cache does not hold", key)
+				}
+				seen[key] = true
+			}
+			if len(seen) != c.Len() {
+				t.Errorf("policy tracked %d keys, cache holds %d", len(seen), c.Len())
+			}
+		})
+	}
+}
+
+func TestShardedWithPolicy(t *testing.T) {
+	c := NewShardedLRUCache(1<<10, 4, WithPolicy(S3FIFO))
+	for _, sh := range c.shards {
+		if _, ok := sh.policy.(*s3fifo); !ok {
+			t.Fatalf("shard policy is %T, want S3FIFO", sh.policy)
+		}
+	}
+	if c.shards[0].policy == c.shards[1].policy {
+		t.Error("shards share one policy instance")
+	}
+}
@@ -0,0 +1,146 @@
+package LruCache
+
+import (
+	"hash/maphash"
+	"runtime"
+)
+
+// ShardedLruCache spreads keys over several independent LruCaches, each with
+// its own lock and an equal slice of the byte budget. An LruCache takes its
+// single lock even to serve a hit, since a hit updates its eviction policy;
+// sharding lets hits on different keys proceed in parallel.
+//
+// Each shard runs its own eviction policy, so eviction order is exact within
+// a shard and only approximate across the cache. A value must fit in one
+// shard's slice of the budget to be stored.
+//
+// It is safe for concurrent use by multiple goroutines.
+type ShardedLruCache struct {
+	seed   maphash.Seed
+	shards []*LruCache
+}
+
+// NewShardedLRUCache returns a cache holding at most maxBytes of response
+// data across shards shards, each configured with opts. Zero or negative
+// shards selects one per CPU, rounded up to a power of two. The shard count
+// is reduced if needed so that every shard has at least one byte of budget.
+// It returns nil if maxBytes is not positive.
+func NewShardedLRUCache(maxBytes int64, shards int, opts ...Option) *ShardedLruCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	if shards <= 0 {
+		shards = 1
+		for shards < runtime.GOMAXPROCS(0) {
+			shards <<= 1
+		}
+	}
+	if int64(shards) > maxBytes {
+		shards = int(maxBytes)
+	}
+	s := &ShardedLruCache{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*LruCache, shards),
+	}
+	// Hand the remainder out one byte at a time so the shards sum to maxBytes.
+	per, extra := maxBytes/int64(shards), maxBytes%int64(shards)
+	for i := range s.shards {
+		budget := per
+		if int64(i) < extra {
+			budget++
+		}
+		s.shards[i] = NewLRUCache(budget, opts...)
+	}
+	s.splitEntryCap()
+	return s
+}
+
+// splitEntryCap divides a WithMaxEntries cap, which each shard received in
+// full, between them, giving every shard at least one entry.
+func (s *ShardedLruCache) splitEntryCap() {
+	n := s.shards[0].maxEntries
+	if n == 0 {
+		return
+	}
+	per, extra := n/len(s.shards), n%len(s.shards)
+	for i, sh := range s.shards {
+		sh.maxEntries = per
+		if i < extra {
+			sh.maxEntries++
+		}
+		sh.maxEntries = max(sh.maxEntries, 1)
+	}
+}
+
+func (s *ShardedLruCache) shard(key string) *LruCache {
+	return s.shards[maphash.String(s.seed, key)%uint64(len(s.shards))]
+}
+
+// Get returns the cached response for key and whether it was present.
+// The returned slice must not be modified by the caller.
+func (s *ShardedLruCache) Get(key string) (responseBytes []byte, ok bool) {
+	return s.shard(key).Get(key)
+}
+
+// Set stores responseBytes under key in its shard, evicting entries chosen
+// by that shard's Policy as needed. See LruCache.Set.
+func (s *ShardedLruCache) Set(key string, responseBytes []byte) {
+	s.shard(key).Set(key, responseBytes)
+}
+
+// Delete removes the entry for key, if present.
+func (s *ShardedLruCache) Delete(key string) {
+	s.shard(key).Delete(key)
+}
+
+// Size returns the total number of bytes currently held. Shards are read one
+// after another, so under concurrent writes the total is approximate.
+func (s *ShardedLruCache) Size() int64 {
+	var n int64
+	for _, sh := range s.shards {
+		n += sh.Size()
+	}
+	return n
+}
+
+// Len returns the number of entries currently held, with the same caveat as
+// Size.
+func (s *ShardedLruCache) Len() int {
+	var n int
+	for _, sh := range s.shards {
+		n += sh.Len()
+	}
+	return n
+}
+
+// Keys returns the keys currently held that start with prefix, in no
+// particular order, with the same caveat as Size.
+func (s *ShardedLruCache) Keys(prefix string) []string {
+	var keys []string
+	for _, sh := range s.shards {
+		keys = append(keys, sh.Keys(prefix)...)
+	}
+	return keys
+}
+
+// Close stops the expiry sweepers of all shards, if any are running.
+func (s *ShardedLruCache) Close() error {
+	for _, sh := range s.shards {
+		sh.Close()
+	}
+	return nil
+}
+
+// Stats returns the counters of all shards added together.
+func (s *ShardedLruCache) Stats() Stats {
+	var total Stats
+	for _, sh := range s.shards {
+		st := sh.Stats()
+		total.Hits += st.Hits
+		total.Misses += st.Misses
+		total.Sets += st.Sets
+		total.Evictions += st.Evictions
+		total.EvictedBytes += st.EvictedBytes
+	}
+	return total
+}
@@ -0,0 +1,137 @@
+package LruCache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+var (
+	_ httpcache.Cache = (*LruCache)(nil)
+	_ httpcache.Cache = (*ShardedLruCache)(nil)
+)
+
+func TestShardedStoresAndDeletes(t *testing.T) {
+	c := NewShardedLRUCache(1<<20, 8)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("k", i), []byte("value"))
+	}
+	if c.Len() != 100 || c.Size() != 500 {
+		t.Errorf("Len = %d, Size = %d; want 100 and 500", c.Len(), c.Size())
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.Get(fmt.Sprint("k", i)); !ok || string(v) != "value" {
+			t.Fatalf("k%d: got %q, %v", i, v, ok)
+		}
+	}
+	c.Delete("k7")
+	if _, ok := c.Get("k7"); ok {
+		t.Error("deleted entry still present")
+	}
+}
+
+func TestShardedBudgetSplitsExactly(t *testing.T) {
+	c := NewShardedLRUCache(103, 4)
+	var total int64
+	for _, sh := range c.shards {
+		total += sh.maxBytes
+	}
+	if total != 103 {
+		t.Errorf("shard budgets sum to %d, want 103", total)
+	}
+
+	// Overfill every shard: the cache as a whole stays within budget.
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprint("k", i), []byte("0123456789"))
+	}
+	if c.Size() > 103 {
+		t.Errorf("Size = %d, exceeds budget of 103", c.Size())
+	}
+}
+
+func TestShardedShardCount(t *testing.T) {
+	if n := len(NewShardedLRUCache(1<<20, 0).shards); n&(n-1) != 0 {
+		t.Errorf("default shard count %d is not a power of two", n)
+	}
+	if n := len(NewShardedLRUCache(3, 16).shards); n != 3 {
+		t.Errorf("a 3-byte budget got %d shards, want 3", n)
+	}
+	if NewShardedLRUCache(0, 4) != nil {
+		t.Error("expected nil for a non-positive budget")
+	}
+}
+
+func TestShardedConcurrentAccess(t *testing.T) {
+	c := NewShardedLRUCache(1<<10, 4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprint("k", (g*i)%64)
+				c.Set(key, []byte(key))
+				if v, ok := c.Get(key); ok && string(v) != key {
+					t.Errorf("key %s returned %q", key, v)
+				}
+				if i%10 == 0 {
+					c.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if c.Size() > 1<<10 {
+		t.Errorf("Size = %d, exceeds budget", c.Size())
+	}
+}
+
+// The benchmarks compare the single-lock cache with the sharded one under a
+// read-heavy parallel load: 90% hits on a working set that fits, 10% writes.
+// Run with -cpu 1,4,16 to see how each scales.
+
+const benchKeys = 4096
+
+func benchKeyNames() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("https://example.com/api/items/%d", i)
+	}
+	return keys
+}
+
+func benchmarkCache(b *testing.B, c httpcache.Cache) {
+	keys := benchKeyNames()
+	value := make([]byte, 1<<10)
+	for _, k := range keys {
+		c.Set(k, value)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			k := keys[i%benchKeys]
+			if i%10 == 0 {
+				c.Set(k, value)
+			} else {
+				c.Get(k)
+			}
+			i += 7919 // a prime stride, so goroutines don't march in step
+		}
+	})
+}
+
+func BenchmarkLruCacheParallel(b *testing.B) {
+	benchmarkCache(b, NewLRUCache(64<<20))
+}
+
+func BenchmarkShardedLruCacheParallel(b *testing.B) {
+	benchmarkCache(b, NewShardedLRUCache(64<<20, 0))
+}
+
+func BenchmarkShardedS3FIFOParallel(b *testing.B) {
+	benchmarkCache(b, NewShardedLRUCache(64<<20, 0, WithPolicy(S3FIFO)))
+}
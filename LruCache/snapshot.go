@@ -0,0 +1,295 @@
+package LruCache
+
+import (
+	"bufio"
+	"container/heap"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// A snapshot is
+//
+//	magic (4) | version (1) | entry count (uvarint)
+//	entry count × ( key length (uvarint) | key | deadline (uvarint) |
+//	                value length (uvarint) | value )
+//	CRC-32C of everything before it (4, big-endian)
+//
+// with entries in the order the policy would evict them, first to go first.
+// The deadline is the entry's WithMaxTTL deadline in Unix nanoseconds, or 0
+// for none.
+var snapshotMagic = []byte("LRUC")
+
+const snapshotVersion = 2
+
+// maxSnapshotKey bounds the key length a snapshot may claim, so a damaged
+// length cannot make the loader allocate without limit.
+const maxSnapshotKey = 1 << 20
+
+// ErrSnapshotVersion is returned when loading data that is not a snapshot,
+// or was written in a format this release does not read.
+var ErrSnapshotVersion = errors.New("LruCache: not a snapshot, or an unsupported snapshot version")
+
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+// OrderedPolicy is implemented by policies that can list the keys they track
+// in the order they would evict them, first to go first. A snapshot of a
+// cache whose policy does not implement it is restored in arbitrary order.
+type OrderedPolicy interface {
+	Policy
+	EvictionOrder() []string
+}
+
+// EvictionOrder lists keys from least to most recently used.
+func (p *lruPolicy) EvictionOrder() []string {
+	keys := make([]string, 0, p.ll.Len())
+	for el := p.ll.Back(); el != nil; el = el.Prev() {
+		keys = append(keys, el.Value.(string))
+	}
+	return keys
+}
+
+// EvictionOrder lists the probationary queue, then the main queue, each
+// oldest first. Access counts are not part of the order, so a restored
+// cache starts every entry on probation.
+func (p *s3fifo) EvictionOrder() []string {
+	keys := make([]string, 0, len(p.entries))
+	for _, q := range []*list.List{p.small, p.main} {
+		for el := q.Back(); el != nil; el = el.Prev() {
+			keys = append(keys, el.Value.(*s3entry).key)
+		}
+	}
+	return keys
+}
+
+// WriteTo writes a snapshot of the cache to w, for LoadLRUCache to restore.
+// The entries are collected under the lock and written after releasing it,
+// so a slow w does not stall the cache; the snapshot reflects the moment
+// WriteTo was called. Entries already past their WithMaxTTL deadline are
+// left out.
+func (l *LruCache) WriteTo(w io.Writer) (int64, error) {
+	l.mu.Lock()
+	var order []string
+	if p, ok := l.policy.(OrderedPolicy); ok {
+		order = p.EvictionOrder()
+	} else {
+		order = make([]string, 0, len(l.items))
+		for key := range l.items {
+			order = append(order, key)
+		}
+	}
+	now := l.now()
+	keys := order[:0]
+	var entries []*entry
+	for _, key := range order {
+		e := l.items[key]
+		if !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			continue
+		}
+		keys = append(keys, key)
+		entries = append(entries, e)
+	}
+	values := make([][]byte, len(keys))
+	deadlines := make([]uint64, len(keys))
+	for i, e := range entries {
+		values[i] = e.value
+		if !e.expiresAt.IsZero() {
+			deadlines[i] = uint64(e.expiresAt.UnixNano())
+		}
+	}
+	l.mu.Unlock()
+
+	sw := &snapshotWriter{w: bufio.NewWriter(w)}
+	sw.write(snapshotMagic)
+	sw.write([]byte{snapshotVersion})
+	sw.uvarint(uint64(len(keys)))
+	for i, key := range keys {
+		sw.uvarint(uint64(len(key)))
+		sw.write([]byte(key))
+		sw.uvarint(deadlines[i])
+		sw.uvarint(uint64(len(values[i])))
+		sw.write(values[i])
+	}
+	sum := sw.sum
+	sw.write(binary.BigEndian.AppendUint32(nil, sum))
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.n, sw.err
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	n   int64
+	sum uint32
+	err error
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.n += int64(n)
+	sw.sum = crc32.Update(sw.sum, snapshotTable, p)
+	sw.err = err
+}
+
+func (sw *snapshotWriter) uvarint(v uint64) {
+	sw.write(binary.AppendUvarint(nil, v))
+}
+
+// LoadLRUCache returns a cache built like NewLRUCache(maxBytes, opts...) and
+// filled from a snapshot written by WriteTo, in the snapshot's eviction
+// order. If the snapshot holds more than the new budget allows, the entries
+// that would have been evicted first are left out. Loading neither calls an
+// OnEvict callback nor counts towards Stats.
+//
+// With WithMaxTTL, each entry keeps the deadline it had when the snapshot
+// was written, or gets the new cache's cap if that is sooner; entries whose
+// deadline has passed are left out. An entry the snapshot holds no deadline
+// for starts a fresh one.
+//
+// It returns ErrSnapshotVersion for data in an unknown format, and an error
+// for a truncated or damaged snapshot; no partially loaded cache is
+// returned.
+func LoadLRUCache(r io.Reader, maxBytes int64, opts ...Option) (*LruCache, error) {
+	l := newLRUCache(maxBytes, opts...)
+	if l == nil {
+		return nil, errors.New("LruCache: maxBytes must be positive")
+	}
+	// Nothing else can reach l until it is returned, and its sweeper is not
+	// running yet, so the callback and the counters can be set aside for
+	// the load without any other activity being lost.
+	onEvict := l.onEvict
+	l.onEvict = nil
+	if err := l.load(r); err != nil {
+		return nil, err
+	}
+	l.onEvict = onEvict
+	l.stats = counters{}
+	l.startSweep()
+	return l, nil
+}
+
+func (l *LruCache) load(r io.Reader) error {
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	header := sr.read(len(snapshotMagic) + 1)
+	if sr.err != nil || string(header[:len(snapshotMagic)]) != string(snapshotMagic) {
+		return ErrSnapshotVersion
+	}
+	if header[len(snapshotMagic)] != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+	now := l.now()
+	count := sr.uvarint()
+	for i := uint64(0); i < count && sr.err == nil; i++ {
+		keyLen := sr.uvarint()
+		if keyLen > maxSnapshotKey {
+			return errors.New("LruCache: corrupt snapshot: implausible key length")
+		}
+		key := string(sr.read(int(keyLen)))
+		var deadline time.Time
+		if ns := sr.uvarint(); ns != 0 {
+			deadline = time.Unix(0, int64(ns))
+		}
+		valueLen := sr.uvarint()
+		if valueLen > uint64(l.maxBytes) {
+			// Too large to store anyway; skip it rather than buffer it.
+			sr.skip(valueLen)
+			continue
+		}
+		value := sr.read(int(valueLen))
+		if sr.err != nil {
+			break
+		}
+		if l.maxTTL > 0 && !deadline.IsZero() {
+			if !now.Before(deadline) {
+				continue
+			}
+			l.Set(key, value)
+			l.restoreDeadline(key, deadline)
+		} else {
+			l.Set(key, value)
+		}
+	}
+	sum := sr.sum
+	trailer := sr.read(4)
+	if sr.err != nil {
+		return fmt.Errorf("LruCache: corrupt snapshot: %w", sr.err)
+	}
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return errors.New("LruCache: corrupt snapshot: checksum mismatch")
+	}
+	return nil
+}
+
+// restoreDeadline brings key's WithMaxTTL deadline forward to deadline, if
+// that is sooner than the one Set just gave it.
+func (l *LruCache) restoreDeadline(key string, deadline time.Time) {
+	l.mu.Lock()
+	defer l.unlock()
+	e, ok := l.items[key]
+	if !ok || !deadline.Before(e.expiresAt) {
+		return
+	}
+	e.expiresAt = deadline
+	if deadline.Before(e.deadline[byDiscard]) {
+		e.deadline[byDiscard] = deadline
+		heap.Fix(&l.expiry[byDiscard], e.heapIdx[byDiscard])
+	}
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	sum uint32
+	err error
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, p); err != nil {
+		sr.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	sr.sum = crc32.Update(sr.sum, snapshotTable, p)
+	return p
+}
+
+func (sr *snapshotReader) skip(n uint64) {
+	for n > 0 && sr.err == nil {
+		chunk := min(n, 32<<10)
+		sr.read(int(chunk))
+		n -= chunk
+	}
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(byteCounter{sr})
+	if err != nil {
+		sr.err = io.ErrUnexpectedEOF
+	}
+	return v
+}
+
+// byteCounter feeds the bytes of a varint through the checksum as
+// binary.ReadUvarint consumes them.
+type byteCounter struct{ sr *snapshotReader }
+
+func (b byteCounter) ReadByte() (byte, error) {
+	c, err := b.sr.r.ReadByte()
+	if err == nil {
+		b.sr.sum = crc32.Update(b.sr.sum, snapshotTable, []byte{c})
+	}
+	return c, err
+}
@@ -0,0 +1,208 @@
+package LruCache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var (
+	_ OrderedPolicy = (*lruPolicy)(nil)
+	_ OrderedPolicy = (*s3fifo)(nil)
+)
+
+func snapshot(t *testing.T, c *LruCache) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	n, err := c.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func TestSnapshotRoundTripKeepsRecency(t *testing.T) {
+	c := NewLRUCache(12)
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	c.Set("c", []byte("cccc"))
+	c.Get("a") // recency is now b, c, a
+
+	restored, err := LoadLRUCache(bytes.NewReader(snapshot(t, c)), 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Len() != 3 || restored.Size() != 12 {
+		t.Fatalf("restored Len = %d, Size = %d; want 3 and 12", restored.Len(), restored.Size())
+	}
+	restored.Set("d", []byte("dddd"))
+	if _, ok := restored.Get("b"); ok {
+		t.Error("b, the least recently used before the snapshot, survived")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := restored.Get(key); !ok {
+			t.Errorf("%s was evicted instead of b", key)
+		}
+	}
+}
+
+// Restoring into a smaller cache keeps the most recently used entries.
+func TestSnapshotRespectsBudgetOnLoad(t *testing.T) {
+	c := NewLRUCache(1 << 10)
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint("k", i), []byte("0123456789"))
+	}
+	c.Set("huge", make([]byte, 500))
+	c.Get("k0")
+
+	restored, err := LoadLRUCache(bytes.NewReader(snapshot(t, c)), 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Size() > 30 {
+		t.Errorf("Size = %d, exceeds the new budget of 30", restored.Size())
+	}
+	for _, key := range []string{"k0", "k9", "k8"} {
+		if _, ok := restored.Get(key); !ok {
+			t.Errorf("recently used %s was not restored", key)
+		}
+	}
+}
+
+func TestSnapshotLoadIsSilent(t *testing.T) {
+	c := NewLRUCache(1 << 10)
+	for i := 0; i < 10; i++ {
+		c.Set(fmt.Sprint("k", i), []byte("0123456789"))
+	}
+	called := false
+	restored, err := LoadLRUCache(bytes.NewReader(snapshot(t, c)), 50,
+		WithOnEvict(func(string, int64, EvictReason) { called = true }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if called {
+		t.Error("OnEvict was called while loading")
+	}
+	if st := restored.Stats(); st != (Stats{}) {
+		t.Errorf("Stats after load = %+v, want zero", st)
+	}
+	restored.Set("new", make([]byte, 10))
+	if !called {
+		t.Error("OnEvict was not reinstated after loading")
+	}
+}
+
+func TestSnapshotWithS3FIFO(t *testing.T) {
+	c := NewLRUCache(1<<10, WithPolicy(S3FIFO))
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprint("k", i), []byte(fmt.Sprint("v", i)))
+	}
+	restored, err := LoadLRUCache(bytes.NewReader(snapshot(t, c)), 1<<10, WithPolicy(S3FIFO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if v, ok := restored.Get(fmt.Sprint("k", i)); !ok || string(v) != fmt.Sprint("v", i) {
+			t.Errorf("k%d restored as %q, %v", i, v, ok)
+		}
+	}
+}
+
+func TestSnapshotRejectsForeignAndOtherFormats(t *testing.T) {
+	c := NewLRUCache(1 << 10)
+	c.Set("k", []byte("v"))
+	good := snapshot(t, c)
+
+	withVersion := func(v byte) []byte {
+		data := bytes.Clone(good)
+		data[len(snapshotMagic)] = v
+		return data
+	}
+	for name, data := range map[string][]byte{
+		"empty":          nil,
+		"foreign":        []byte("HTTP/1.1 200 OK\r\n\r\n"),
+		"version 1":      withVersion(1),
+		"future version": withVersion(snapshotVersion + 1),
+	} {
+		if _, err := LoadLRUCache(bytes.NewReader(data), 1<<10); !errors.Is(err, ErrSnapshotVersion) {
+			t.Errorf("%s: err = %v, want ErrSnapshotVersion", name, err)
+		}
+	}
+}
+
+func TestSnapshotDetectsDamage(t *testing.T) {
+	c := NewLRUCache(1 << 10)
+	c.Set("key", []byte("value"))
+	c.Set("other", []byte("another value"))
+	good := snapshot(t, c)
+
+	for n := 0; n < len(good); n++ {
+		if _, err := LoadLRUCache(bytes.NewReader(good[:n]), 1<<10); err == nil {
+			t.Errorf("snapshot truncated to %d bytes was accepted", n)
+		}
+	}
+	for i := range good {
+		damaged := bytes.Clone(good)
+		damaged[i] ^= 0x01
+		if _, err := LoadLRUCache(bytes.NewReader(damaged), 1<<10); err == nil {
+			t.Errorf("snapshot with byte %d flipped was accepted", i)
+		}
+	}
+}
+
+// A save and load must not reset the WithMaxTTL cap: expired entries stay
+// out of the snapshot, and the rest keep their deadlines.
+func TestSnapshotKeepsMaxTTLDeadlines(t *testing.T) {
+	base := time.Now().Add(-70 * time.Second)
+	now := base
+	c := NewLRUCache(1<<10, WithMaxTTL(time.Minute))
+	c.now = func() time.Time { return now }
+	c.Set("old", []byte("o")) // expires at base+60s, ten seconds ago
+	now = base.Add(50 * time.Second)
+	c.Set("new", []byte("n")) // expires at base+110s, forty seconds from now
+	now = base.Add(70 * time.Second)
+	data := snapshot(t, c)
+
+	uncapped, err := LoadLRUCache(bytes.NewReader(data), 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := uncapped.Get("old"); ok {
+		t.Error("an entry past its deadline was written to the snapshot")
+	}
+
+	restored, err := LoadLRUCache(bytes.NewReader(data), 1<<10, WithMaxTTL(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := restored.items["new"]
+	if !ok {
+		t.Fatal("new was not restored")
+	}
+	if want := base.Add(110 * time.Second); !e.expiresAt.Equal(want) {
+		t.Errorf("restored deadline = %v, want %v", e.expiresAt, want)
+	}
+}
+
+// Loading must not race a running sweeper; run under -race.
+func TestSnapshotLoadWithSweeper(t *testing.T) {
+	c := NewLRUCache(1 << 10)
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("k", i), []byte("0123456789"))
+	}
+	restored, err := LoadLRUCache(bytes.NewReader(snapshot(t, c)), 1<<10,
+		WithExpirySweep(time.Microsecond),
+		WithOnEvict(func(string, int64, EvictReason) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if restored.Len() != 100 {
+		t.Errorf("restored Len = %d, want 100", restored.Len())
+	}
+}
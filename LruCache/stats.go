@@ -0,0 +1,104 @@
+package LruCache
+
+import "sync/atomic"
+
+// EvictReason says why an entry left the cache.
+type EvictReason int
+
+const (
+	// EvictCapacity: removed to make room within the byte budget or the
+	// entry cap.
+	EvictCapacity EvictReason = iota
+	// EvictReplaced: the value was overwritten by a Set for the same key.
+	EvictReplaced
+	// EvictOversized: a Set was refused because the value alone exceeds the
+	// budget. The size reported is the refused value's.
+	EvictOversized
+	// EvictDeleted: removed by Delete.
+	EvictDeleted
+	// EvictExpired: past its WithMaxTTL cap, or swept by WithExpirySweep.
+	EvictExpired
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictReplaced:
+		return "replaced"
+	case EvictOversized:
+		return "oversized"
+	case EvictDeleted:
+		return "deleted"
+	case EvictExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// WithOnEvict registers fn to be called whenever an entry leaves the cache or
+// a value is refused, with the size it counted against the budget. fn runs
+// after the cache's lock is released, so it may use the cache, but calls
+// from concurrent operations may arrive concurrently and out of order.
+func WithOnEvict(fn func(key string, size int64, reason EvictReason)) Option {
+	return func(l *LruCache) {
+		l.onEvict = fn
+	}
+}
+
+// Stats are cumulative counters for an LruCache.
+type Stats struct {
+	Hits   int64
+	Misses int64
+	Sets   int64
+	// Evictions counts entries removed by the cache itself, for capacity or
+	// expiry; EvictedBytes is their total size. Deletes and overwrites are
+	// not counted.
+	Evictions    int64
+	EvictedBytes int64
+}
+
+type counters struct {
+	hits, misses, sets, evictions, evictedBytes atomic.Int64
+}
+
+// Stats returns the cache's counters. It does not take the cache's lock, so
+// under concurrent use the fields may be mutually slightly out of date.
+func (l *LruCache) Stats() Stats {
+	return Stats{
+		Hits:         l.stats.hits.Load(),
+		Misses:       l.stats.misses.Load(),
+		Sets:         l.stats.sets.Load(),
+		Evictions:    l.stats.evictions.Load(),
+		EvictedBytes: l.stats.evictedBytes.Load(),
+	}
+}
+
+type eviction struct {
+	key    string
+	size   int64
+	reason EvictReason
+}
+
+// evictedLocked records that key, which counted size, left the cache.
+func (l *LruCache) evictedLocked(key string, size int64, reason EvictReason) {
+	if reason == EvictCapacity || reason == EvictExpired {
+		l.stats.evictions.Add(1)
+		l.stats.evictedBytes.Add(size)
+	}
+	if l.onEvict != nil {
+		l.pending = append(l.pending, eviction{key, size, reason})
+	}
+}
+
+// unlock releases the cache's lock and then reports the evictions made while
+// it was held.
+func (l *LruCache) unlock() {
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	for _, ev := range pending {
+		l.onEvict(ev.key, ev.size, ev.reason)
+	}
+}
@@ -0,0 +1,108 @@
+package LruCache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type evictionLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *evictionLog) record(key string, size int64, reason EvictReason) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf("%s:%d:%s", key, size, reason))
+}
+
+func (l *evictionLog) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+func TestOnEvictReasons(t *testing.T) {
+	var log evictionLog
+	now := epoch
+	c := NewLRUCache(10, WithOnEvict(log.record), WithMaxTTL(time.Minute))
+	c.now = func() time.Time { return now }
+
+	steps := []struct {
+		do   func()
+		want []string
+	}{
+		{func() { c.Set("a", []byte("aaaa")) }, nil},
+		{func() { c.Set("a", []byte("aaa")) }, []string{"a:4:replaced"}},
+		{func() { c.Set("b", []byte("bbbb")) }, nil},
+		{func() { c.Set("c", []byte("cccc")) }, []string{"a:3:capacity"}},
+		{func() { c.Set("c", make([]byte, 11)) }, []string{"c:4:replaced", "c:11:oversized"}},
+		{func() { c.Delete("b") }, []string{"b:4:deleted"}},
+		{func() { c.Delete("b") }, nil},
+		{func() { c.Set("d", []byte("d")); now = now.Add(time.Minute); c.Get("d") }, []string{"d:1:expired"}},
+	}
+	for i, step := range steps {
+		step.do()
+		got := log.take()
+		if fmt.Sprint(got) != fmt.Sprint(step.want) {
+			t.Errorf("step %d: evictions %v, want %v", i, got, step.want)
+		}
+	}
+}
+
+// The callback runs outside the lock, so it can use the cache.
+func TestOnEvictMayCallBack(t *testing.T) {
+	var c *LruCache
+	var seen []string
+	c = NewLRUCache(4, WithOnEvict(func(key string, _ int64, _ EvictReason) {
+		_, ok := c.Get(key)
+		seen = append(seen, fmt.Sprint(key, ok))
+	}))
+	c.Set("a", []byte("aaaa"))
+	done := make(chan struct{})
+	go func() {
+		c.Set("b", []byte("bbbb"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnEvict calling into the cache deadlocked")
+	}
+	if fmt.Sprint(seen) != "[afalse]" {
+		t.Errorf("callback saw %v, want [afalse]", seen)
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := NewLRUCache(8)
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	c.Get("a")
+	c.Get("missing")
+	c.Set("c", []byte("cccc")) // evicts b
+	c.Set("c", []byte("cc"))   // an overwrite, not an eviction
+	c.Delete("a")              // nor is a delete
+
+	want := Stats{Hits: 1, Misses: 1, Sets: 4, Evictions: 1, EvictedBytes: 4}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestShardedStatsAddUp(t *testing.T) {
+	c := NewShardedLRUCache(1<<20, 4)
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprint("k", i), []byte("v"))
+		c.Get(fmt.Sprint("k", i))
+		c.Get(fmt.Sprint("missing", i))
+	}
+	want := Stats{Hits: 20, Misses: 20, Sets: 20}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+// Package Namespace provides an httpcache.Cache wrapper that partitions one
+// backend between several users, such as the transports of different API
+// clients, so they share its budget without their keys colliding.
+package Namespace
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/ferocious-space/httpcache"
+	"github.com/ferocious-space/httpcache/internal/nilcache"
+)
+
+// separator ends the prefix in every stored key. It cannot appear in a
+// prefix, so no namespace's keys can start with another's prefix: without it,
+// purging "api1" would also purge "api10".
+const separator = "\x00"
+
+// KeyLister is implemented by backends that can enumerate their keys, such
+// as LruCache and ShardedLruCache.
+type KeyLister interface {
+	// Keys returns the keys currently held that start with prefix.
+	Keys(prefix string) []string
+}
+
+// Namespace stores its entries in a shared backend under its own prefix.
+//
+// It keeps an index of the keys it has stored, with their sizes, for Purge
+// and for the quota. The backend may evict entries without telling it; such
+// a key stays in the index, still counted against the quota, until a Get
+// finds it missing.
+//
+// It is safe for concurrent use if the backend is.
+type Namespace struct {
+	cache  httpcache.Cache
+	prefix string
+	quota  int64
+
+	mu    sync.Mutex
+	used  int64
+	order *list.List // of *indexed; front is most recently used
+	index map[string]*list.Element
+}
+
+type indexed struct {
+	key  string
+	size int64
+}
+
+// Option configures a Namespace.
+type Option func(*Namespace)
+
+// WithQuota bounds the bytes of responses the namespace may hold in the
+// backend. Past it, the namespace deletes its own least recently used
+// entries, so one busy client cannot push every other one out of a shared
+// budget. A response larger than the whole quota is not stored.
+func WithQuota(maxBytes int64) Option {
+	return func(n *Namespace) {
+		if maxBytes > 0 {
+			n.quota = maxBytes
+		}
+	}
+}
+
+// NewNamespace returns a view of c in which every key is stored under
+// prefix. prefix must be non-empty and must not contain a NUL byte.
+func NewNamespace(c httpcache.Cache, prefix string, opts ...Option) (*Namespace, error) {
+	if nilcache.IsNil(c) {
+		return nil, errors.New("Namespace: underlying cache is nil")
+	}
+	if prefix == "" {
+		return nil, errors.New("Namespace: empty prefix")
+	}
+	if strings.Contains(prefix, separator) {
+		return nil, errors.New("Namespace: prefix contains a NUL byte")
+	}
+	n := &Namespace{
+		cache:  c,
+		prefix: prefix + separator,
+		order:  list.New(),
+		index:  make(map[string]*list.Element),
+	}
+	for _, o := range opts {
+		o(n)
+	}
+	return n, nil
+}
+
+// Get returns the value stored under key in this namespace.
+func (n *Namespace) Get(key string) ([]byte, bool) {
+	value, ok := n.cache.Get(n.prefix + key)
+	n.mu.Lock()
+	if !ok {
+		n.forgetLocked(key)
+		n.mu.Unlock()
+		return nil, false
+	}
+	if el, tracked := n.index[key]; tracked {
+		n.order.MoveToFront(el)
+		n.mu.Unlock()
+		return value, true
+	}
+	// Stored by an earlier Namespace on the same prefix, perhaps in another
+	// process: count it from now on, within the quota like a Set.
+	size := int64(len(value))
+	if n.quota > 0 && size > n.quota {
+		n.mu.Unlock()
+		n.cache.Delete(n.prefix + key)
+		return value, true
+	}
+	victims := n.makeRoomLocked(size)
+	n.trackLocked(key, size)
+	n.mu.Unlock()
+	n.deleteAll(victims)
+	return value, true
+}
+
+// Set stores value under key in this namespace, first making room within the
+// quota, if there is one, by deleting the namespace's least recently used
+// entries.
+func (n *Namespace) Set(key string, value []byte) {
+	size := int64(len(value))
+	if n.quota > 0 && size > n.quota {
+		n.Delete(key)
+		return
+	}
+	n.mu.Lock()
+	n.forgetLocked(key)
+	victims := n.makeRoomLocked(size)
+	n.trackLocked(key, size)
+	n.mu.Unlock()
+
+	n.deleteAll(victims)
+	n.cache.Set(n.prefix+key, value)
+}
+
+// Delete removes key from this namespace.
+func (n *Namespace) Delete(key string) {
+	n.mu.Lock()
+	n.forgetLocked(key)
+	n.mu.Unlock()
+	n.cache.Delete(n.prefix + key)
+}
+
+// Purge deletes every entry in this namespace, leaving the rest of the
+// backend alone. If the backend is a KeyLister, that includes entries stored
+// by an earlier Namespace on the same prefix; otherwise only those in the
+// index are found.
+func (n *Namespace) Purge() {
+	n.mu.Lock()
+	keys := make([]string, 0, len(n.index))
+	for key := range n.index {
+		keys = append(keys, n.prefix+key)
+	}
+	n.order.Init()
+	clear(n.index)
+	n.used = 0
+	n.mu.Unlock()
+
+	if lister, ok := n.cache.(KeyLister); ok {
+		keys = lister.Keys(n.prefix)
+	}
+	for _, key := range keys {
+		n.cache.Delete(key)
+	}
+}
+
+// Size returns the bytes of responses the namespace holds, as counted
+// against its quota.
+func (n *Namespace) Size() int64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.used
+}
+
+// makeRoomLocked forgets the namespace's least recently used keys until size
+// more bytes fit within the quota, and returns them for deleteAll.
+func (n *Namespace) makeRoomLocked(size int64) []string {
+	var victims []string
+	for n.quota > 0 && n.used+size > n.quota && n.order.Len() > 0 {
+		victim := n.order.Back().Value.(*indexed).key
+		n.forgetLocked(victim)
+		victims = append(victims, victim)
+	}
+	return victims
+}
+
+// deleteAll removes keys from the backend. It is called without n.mu held,
+// so a slow backend does not stall the namespace.
+func (n *Namespace) deleteAll(keys []string) {
+	for _, key := range keys {
+		n.cache.Delete(n.prefix + key)
+	}
+}
+
+func (n *Namespace) trackLocked(key string, size int64) {
+	n.index[key] = n.order.PushFront(&indexed{key: key, size: size})
+	n.used += size
+}
+
+func (n *Namespace) forgetLocked(key string) {
+	if el, ok := n.index[key]; ok {
+		n.order.Remove(el)
+		delete(n.index, key)
+		n.used -= el.Value.(*indexed).size
+	}
+}
@@ -0,0 +1,228 @@
+package Namespace
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ferocious-space/httpcache/LruCache"
+)
+
+// mapCache is a minimal backend for tests. Unlike LruCache it cannot list its
+// keys.
+type mapCache struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func newMapCache() *mapCache { return &mapCache{m: map[string][]byte{}} }
+func (c *mapCache) Get(k string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[k]
+	return v, ok
+}
+func (c *mapCache) Set(k string, v []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.m[k] = v
+}
+func (c *mapCache) Delete(k string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.m, k)
+}
+
+func mustNamespace(t *testing.T, c *LruCache.LruCache, prefix string, opts ...Option) *Namespace {
+	t.Helper()
+	n, err := NewNamespace(c, prefix, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNamespacesDoNotCollide(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	a := mustNamespace(t, shared, "a")
+	b := mustNamespace(t, shared, "b")
+
+	a.Set("k", []byte("from a"))
+	b.Set("k", []byte("from b"))
+	if v, _ := a.Get("k"); string(v) != "from a" {
+		t.Errorf("a.Get = %q, want %q", v, "from a")
+	}
+	if v, _ := b.Get("k"); string(v) != "from b" {
+		t.Errorf("b.Get = %q, want %q", v, "from b")
+	}
+	a.Delete("k")
+	if _, ok := b.Get("k"); !ok {
+		t.Error("a.Delete removed b's entry")
+	}
+}
+
+func TestPurgeLeavesOtherNamespaces(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	api1 := mustNamespace(t, shared, "api1")
+	api10 := mustNamespace(t, shared, "api10")
+	for i := 0; i < 5; i++ {
+		api1.Set(fmt.Sprint("k", i), []byte("v"))
+		api10.Set(fmt.Sprint("0k", i), []byte("v"))
+	}
+	shared.Set("outside", []byte("v"))
+
+	api1.Purge()
+	if n := len(shared.Keys("")); n != 6 {
+		t.Errorf("%d entries left after purging api1, want api10's 5 and one outside", n)
+	}
+	if _, ok := api10.Get("0k0"); !ok {
+		t.Error("purging api1 removed an entry of api10")
+	}
+	if n := api1.Size(); n != 0 {
+		t.Errorf("Size after Purge = %d, want 0", n)
+	}
+}
+
+// With a backend that can list its keys, Purge also finds entries the
+// namespace did not store itself, e.g. before a restart.
+func TestPurgeEnumeratesBackend(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	mustNamespace(t, shared, "api").Set("old", []byte("v"))
+
+	fresh := mustNamespace(t, shared, "api")
+	fresh.Purge()
+	if _, ok := shared.Get("api\x00old"); ok {
+		t.Error("Purge missed an entry stored by an earlier namespace")
+	}
+}
+
+func TestPurgeWithoutEnumerationUsesIndex(t *testing.T) {
+	shared := newMapCache()
+	n, err := NewNamespace(shared, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.Set("a", []byte("v"))
+	n.Set("b", []byte("v"))
+	shared.Set("other", []byte("v"))
+	n.Purge()
+	if len(shared.m) != 1 {
+		t.Errorf("backend holds %d entries after Purge, want only the outside one", len(shared.m))
+	}
+}
+
+func TestQuotaEvictsOwnLeastRecentlyUsed(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	busy := mustNamespace(t, shared, "busy", WithQuota(30))
+	quiet := mustNamespace(t, shared, "quiet")
+	quiet.Set("k", []byte("v"))
+
+	value := bytes.Repeat([]byte("x"), 10)
+	busy.Set("k0", value)
+	busy.Set("k1", value)
+	busy.Set("k2", value)
+	busy.Get("k0") // k1 is now least recently used
+	busy.Set("k3", value)
+
+	for key, want := range map[string]bool{"k0": true, "k1": false, "k2": true, "k3": true} {
+		if _, ok := busy.Get(key); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+	if n := busy.Size(); n != 30 {
+		t.Errorf("Size = %d, want 30", n)
+	}
+	if _, ok := quiet.Get("k"); !ok {
+		t.Error("another namespace's entry was evicted for the quota")
+	}
+
+	busy.Set("huge", bytes.Repeat([]byte("x"), 31))
+	if _, ok := busy.Get("huge"); ok {
+		t.Error("a value over the whole quota was stored")
+	}
+}
+
+// An entry the backend evicted stops counting once a Get notices.
+func TestGetMissReconcilesIndex(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	n := mustNamespace(t, shared, "api", WithQuota(100))
+	n.Set("k", bytes.Repeat([]byte("x"), 40))
+	shared.Delete("api\x00k") // evicted behind the namespace's back
+	if _, ok := n.Get("k"); ok {
+		t.Fatal("Get reported a hit for an evicted entry")
+	}
+	if s := n.Size(); s != 0 {
+		t.Errorf("Size = %d after the miss, want 0", s)
+	}
+}
+
+// Entries left by an earlier Namespace on the prefix are adopted by Get
+// within the quota, not on top of it.
+func TestAdoptedEntriesRespectQuota(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	earlier := mustNamespace(t, shared, "api")
+	value := bytes.Repeat([]byte("x"), 10)
+	for i := range 5 {
+		earlier.Set(fmt.Sprint("k", i), value)
+	}
+	earlier.Set("huge", bytes.Repeat([]byte("x"), 31))
+
+	n := mustNamespace(t, shared, "api", WithQuota(30))
+	for i := range 5 {
+		if _, ok := n.Get(fmt.Sprint("k", i)); !ok {
+			t.Fatalf("k%d was not found", i)
+		}
+		if s := n.Size(); s > 30 {
+			t.Fatalf("Size = %d after adopting k%d, over the quota of 30", s, i)
+		}
+	}
+	for key, want := range map[string]bool{"k0": false, "k1": false, "k2": true, "k3": true, "k4": true} {
+		if _, ok := shared.Get("api\x00" + key); ok != want {
+			t.Errorf("%s in backend = %v, want %v", key, ok, want)
+		}
+	}
+	if _, ok := n.Get("huge"); !ok {
+		t.Error("Get of a value over the quota missed rather than returning it")
+	}
+	if _, ok := shared.Get("api\x00huge"); ok {
+		t.Error("a value over the whole quota was adopted")
+	}
+	if s := n.Size(); s != 30 {
+		t.Errorf("Size = %d, want 30", s)
+	}
+}
+
+func TestNewNamespaceRejectsBadArguments(t *testing.T) {
+	var typedNil *LruCache.LruCache
+	if _, err := NewNamespace(typedNil, "p"); err == nil {
+		t.Error("expected an error for a nil cache")
+	}
+	if _, err := NewNamespace(newMapCache(), ""); err == nil {
+		t.Error("expected an error for an empty prefix")
+	}
+	if _, err := NewNamespace(newMapCache(), "a\x00b"); err == nil {
+		t.Error("expected an error for a prefix containing NUL")
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	shared := LruCache.NewLRUCache(1 << 20)
+	n := mustNamespace(t, shared, "api", WithQuota(200))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				k := fmt.Sprint("k", j%20)
+				n.Set(k, []byte(fmt.Sprint("v", i, j)))
+				n.Get(k)
+				if j%13 == 0 {
+					n.Purge()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
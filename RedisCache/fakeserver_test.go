@@ -0,0 +1,230 @@
+package RedisCache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process stand-in for a Redis server. It speaks just
+// enough RESP for RedisCache: PING, AUTH, SELECT, GET, SET with PX or EX,
+// DEL, and SCAN with a MATCH pattern of an escaped prefix and a trailing *.
+// Its parser is independent of the client's, so the two check each other.
+type fakeRedis struct {
+	ln       net.Listener
+	accepted atomic.Int64
+
+	mu       sync.Mutex
+	password string
+	data     map[string]fakeEntry
+	conns    []net.Conn
+}
+
+type fakeEntry struct {
+	value []byte
+	ttl   time.Duration // as given by PX or EX; zero for none
+	db    int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, data: make(map[string]fakeEntry)}
+	go f.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		f.dropConnections()
+	})
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+// requirePassword makes connections accepted from now on authenticate.
+func (f *fakeRedis) requirePassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.password = password
+}
+
+func (f *fakeRedis) entry(key string) (fakeEntry, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.data[key]
+	return e, ok
+}
+
+// dropConnections closes every connection accepted so far, as a server
+// restart would.
+func (f *fakeRedis) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, nc := range f.conns {
+		nc.Close()
+	}
+	f.conns = nil
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		nc, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.accepted.Add(1)
+		f.mu.Lock()
+		f.conns = append(f.conns, nc)
+		f.mu.Unlock()
+		go f.handle(nc)
+	}
+}
+
+func (f *fakeRedis) handle(nc net.Conn) {
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+	f.mu.Lock()
+	password := f.password
+	f.mu.Unlock()
+	authed := password == ""
+	db := 0
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(string(args[0]))
+		switch {
+		case name == "AUTH":
+			if string(args[len(args)-1]) != password {
+				fmt.Fprint(w, "-WRONGPASS invalid username-password pair\r\n")
+				break
+			}
+			authed = true
+			fmt.Fprint(w, "+OK\r\n")
+		case !authed:
+			fmt.Fprint(w, "-NOAUTH Authentication required.\r\n")
+		case name == "PING":
+			fmt.Fprint(w, "+PONG\r\n")
+		case name == "SELECT":
+			db, _ = strconv.Atoi(string(args[1]))
+			fmt.Fprint(w, "+OK\r\n")
+		case name == "GET":
+			e, ok := f.entry(string(args[1]))
+			if !ok || e.db != db {
+				fmt.Fprint(w, "$-1\r\n")
+				break
+			}
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(e.value), e.value)
+		case name == "SET":
+			e := fakeEntry{value: args[2], db: db}
+			if len(args) == 5 {
+				n, _ := strconv.ParseInt(string(args[4]), 10, 64)
+				unit := time.Millisecond
+				if strings.EqualFold(string(args[3]), "EX") {
+					unit = time.Second
+				}
+				e.ttl = time.Duration(n) * unit
+			}
+			f.mu.Lock()
+			f.data[string(args[1])] = e
+			f.mu.Unlock()
+			fmt.Fprint(w, "+OK\r\n")
+		case name == "DEL":
+			n := 0
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				if _, ok := f.data[string(key)]; ok {
+					delete(f.data, string(key))
+					n++
+				}
+			}
+			f.mu.Unlock()
+			fmt.Fprintf(w, ":%d\r\n", n)
+		case name == "SCAN":
+			f.scan(w, args)
+		default:
+			fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		// Reply to a pipeline in one write, as Redis does.
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// scan answers SCAN two keys at a time, so clients must follow the cursor.
+func (f *fakeRedis) scan(w io.Writer, args [][]byte) {
+	offset, _ := strconv.Atoi(string(args[1]))
+	var prefix []byte
+	pattern := args[3]
+	for i := 0; i < len(pattern)-1; i++ {
+		if pattern[i] == '\\' {
+			i++
+		}
+		prefix = append(prefix, pattern[i])
+	}
+	f.mu.Lock()
+	var keys []string
+	for key := range f.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	f.mu.Unlock()
+	slices.Sort(keys)
+	page := keys[min(offset, len(keys)):min(offset+2, len(keys))]
+	next := strconv.Itoa(offset + 2)
+	if offset+2 >= len(keys) {
+		next = "0"
+	}
+	fmt.Fprintf(w, "*2\r\n$%d\r\n%s\r\n*%d\r\n", len(next), next, len(page))
+	for _, key := range page {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(key), key)
+	}
+}
+
+// readCommand reads one command, an array of bulk strings.
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("fakeRedis: not an array: %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("fakeRedis: bad array length: %q", line)
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("fakeRedis: bad bulk length: %q", line)
+		}
+		args[i] = make([]byte, size+2)
+		if _, err := io.ReadFull(r, args[i]); err != nil {
+			return nil, err
+		}
+		args[i] = args[i][:size]
+	}
+	return args, nil
+}
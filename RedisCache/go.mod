@@ -0,0 +1,10 @@
+// RedisCache is a separate module so that the httpcache library stays free of
+// dependencies its users did not ask for. It needs nothing beyond the
+// standard library itself: the RESP client is written out in this package.
+module github.com/ferocious-space/httpcache/RedisCache
+
+go 1.26
+
+require github.com/ferocious-space/httpcache v0.0.0-20261019090956-eaf09c024f73
+
+require golang.org/x/sync v0.22.0 // indirect
@@ -0,0 +1,142 @@
+package RedisCache
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var errClosed = errors.New("RedisCache: cache is closed")
+
+// pool hands out connections, keeping up to cfg.poolSize of them open
+// between uses and, if cfg.maxConns is positive, no more than that open at
+// once: a caller that finds none idle and the limit reached waits for one to
+// be returned or closed.
+type pool struct {
+	dial func(ctx context.Context) (net.Conn, error)
+	cfg  *config
+
+	mu     sync.Mutex
+	freed  sync.Cond // on mu; signalled when a connection is returned or closed
+	idle   []*conn
+	open   int
+	closed bool
+}
+
+func (p *pool) get(ctx context.Context) (*conn, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, errClosed
+		}
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			p.mu.Unlock()
+			return c, nil
+		}
+		if p.cfg.maxConns <= 0 || p.open < p.cfg.maxConns {
+			break
+		}
+		p.freed.Wait()
+	}
+	p.open++
+	p.mu.Unlock()
+
+	c, err := p.connect(ctx)
+	if err != nil {
+		p.release()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect dials and sets up a new connection.
+func (p *pool) connect(ctx context.Context) (*conn, error) {
+	if p.cfg.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.cfg.dialTimeout)
+		defer cancel()
+	}
+	nc, err := p.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(nc, p.cfg.timeout)
+	if err := p.setUp(c); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// release gives up the place of a connection that has been closed.
+func (p *pool) release() {
+	p.mu.Lock()
+	p.open--
+	p.mu.Unlock()
+	p.freed.Signal()
+}
+
+// setUp authenticates a new connection and selects its database.
+func (p *pool) setUp(c *conn) error {
+	if p.cfg.password != "" {
+		args := [][]byte{[]byte("AUTH"), []byte(p.cfg.password)}
+		if p.cfg.username != "" {
+			args = [][]byte{[]byte("AUTH"), []byte(p.cfg.username), []byte(p.cfg.password)}
+		}
+		if err := expectOK(c.do(args...)); err != nil {
+			return err
+		}
+	}
+	if p.cfg.db != 0 {
+		if err := expectOK(c.do([]byte("SELECT"), []byte(strconv.Itoa(p.cfg.db)))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put returns c to the pool, or closes it if it is broken or the pool is
+// full or closed.
+func (p *pool) put(c *conn) {
+	if !c.broken {
+		p.mu.Lock()
+		if !p.closed && len(p.idle) < p.cfg.poolSize {
+			p.idle = append(p.idle, c)
+			p.mu.Unlock()
+			p.freed.Signal()
+			return
+		}
+		p.mu.Unlock()
+	}
+	c.close()
+	p.release()
+}
+
+func (p *pool) close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.open -= len(idle)
+	p.mu.Unlock()
+	p.freed.Broadcast()
+	var errs []error
+	for _, c := range idle {
+		errs = append(errs, c.close())
+	}
+	return errors.Join(errs...)
+}
+
+func expectOK(reply any, err error) error {
+	if err != nil {
+		return err
+	}
+	if e, ok := reply.(Error); ok {
+		return e
+	}
+	return nil
+}
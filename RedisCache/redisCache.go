@@ -0,0 +1,370 @@
+// Package RedisCache provides an httpcache.Cache backed by Redis, or any
+// server speaking its protocol (RESP), such as Valkey or KeyDB.
+package RedisCache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+const (
+	// DefaultPoolSize is how many idle connections are kept open when
+	// WithPoolSize is not given.
+	DefaultPoolSize = 8
+	// DefaultTimeout bounds each round trip when WithTimeout is not given.
+	// A cache that stalls a request for longer than this is worse than a
+	// miss.
+	DefaultTimeout = time.Second
+	// DefaultDialTimeout bounds connecting when WithDialTimeout is not given.
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultMaxConns is how many connections may be open at once when
+	// WithMaxConns is not given.
+	DefaultMaxConns = 64
+	// DefaultStaleGrace is how long an entry with validators is kept past
+	// use when WithStaleGrace is not given.
+	DefaultStaleGrace = time.Hour
+)
+
+// RedisCache stores responses in Redis, each under its key with an optional
+// prefix. Entries expire on their own once the transport could no longer use
+// them: at the end of their freshness lifetime plus any stale-if-error
+// window, read from the response's headers.
+//
+// The Cache interface has no way to return errors, so a failed Get is a
+// miss and a failed Set or Delete is dropped; WithOnError reports them.
+//
+// It is safe for concurrent use by multiple goroutines.
+type RedisCache struct {
+	pool *pool
+	cfg  config
+	now  func() time.Time
+}
+
+// Option configures a RedisCache.
+type Option func(*config)
+
+type config struct {
+	prefix      string
+	poolSize    int
+	maxConns    int
+	timeout     time.Duration
+	dialTimeout time.Duration
+	dialer      func(ctx context.Context, network, addr string) (net.Conn, error)
+	username    string
+	password    string
+	db          int
+	defaultTTL  time.Duration
+	staleGrace  time.Duration
+	onError     func(error)
+}
+
+// WithPrefix prepends prefix to every key, so several caches, or a cache and
+// other data, can share one database.
+func WithPrefix(prefix string) Option {
+	return func(c *config) {
+		c.prefix = prefix
+	}
+}
+
+// WithPoolSize sets how many idle connections are kept open between uses.
+// Concurrent operations beyond that dial extra connections, up to the
+// WithMaxConns limit, which are closed once they are done.
+func WithPoolSize(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.poolSize = n
+		}
+	}
+}
+
+// WithMaxConns limits how many connections are open at once. An operation
+// that finds the limit reached waits for another to finish. Zero or negative
+// removes the limit, so every concurrent operation may dial.
+func WithMaxConns(n int) Option {
+	return func(c *config) {
+		c.maxConns = n
+	}
+}
+
+// WithTimeout bounds each round trip to the server. Zero disables the bound.
+func WithTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.timeout = d
+	}
+}
+
+// WithDialTimeout bounds connecting to the server. Zero disables the bound.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.dialTimeout = d
+	}
+}
+
+// WithDialer replaces the net.Dialer used to connect, for example with a
+// tls.Dialer.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(c *config) {
+		c.dialer = dial
+	}
+}
+
+// WithAuth authenticates each new connection. An empty username uses the
+// single-password form of AUTH.
+func WithAuth(username, password string) Option {
+	return func(c *config) {
+		c.username, c.password = username, password
+	}
+}
+
+// WithDB selects the numbered database on each new connection.
+func WithDB(db int) Option {
+	return func(c *config) {
+		c.db = db
+	}
+}
+
+// WithDefaultTTL sets the expiry of entries whose headers give none to act
+// on: immutable responses, responses without a Date, stale-if-error without
+// a limit, and values made opaque by a wrapper such as CompressingCache or
+// EncryptingCache. Without it such entries never expire.
+func WithDefaultTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.defaultTTL = d
+	}
+}
+
+// WithStaleGrace keeps entries that carry an ETag or Last-Modified for d
+// past the point the transport could serve them without asking the origin,
+// DefaultStaleGrace if not given. Such a stale entry still saves bandwidth:
+// the transport revalidates it, and a 304 is cheaper than a full response.
+// That includes responses that are stale on arrival, such as no-cache or
+// max-age=0 ones, which are stored only to be revalidated. An entry without
+// validators is worthless once stale and gets no grace.
+func WithStaleGrace(d time.Duration) Option {
+	return func(c *config) {
+		c.staleGrace = d
+	}
+}
+
+// WithOnError sets a function called with every error the cache swallows.
+func WithOnError(fn func(error)) Option {
+	return func(c *config) {
+		c.onError = fn
+	}
+}
+
+// NewRedisCache returns a cache storing entries on the server at addr, a
+// host:port. No connection is made until the cache is first used; call Ping
+// to check the server is reachable.
+func NewRedisCache(addr string, opts ...Option) (*RedisCache, error) {
+	if addr == "" {
+		return nil, errors.New("RedisCache: empty address")
+	}
+	cfg := config{
+		poolSize:    DefaultPoolSize,
+		maxConns:    DefaultMaxConns,
+		staleGrace:  DefaultStaleGrace,
+		timeout:     DefaultTimeout,
+		dialTimeout: DefaultDialTimeout,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.dialer == nil {
+		cfg.dialer = (&net.Dialer{}).DialContext
+	}
+	r := &RedisCache{cfg: cfg, now: time.Now}
+	r.pool = &pool{
+		dial: func(ctx context.Context) (net.Conn, error) { return r.cfg.dialer(ctx, "tcp", addr) },
+		cfg:  &r.cfg,
+	}
+	r.pool.freed.L = &r.pool.mu
+	return r, nil
+}
+
+// Get returns the value stored under key.
+func (r *RedisCache) Get(key string) ([]byte, bool) {
+	var value []byte
+	err := r.with(func(c *conn) error {
+		reply, err := c.do([]byte("GET"), r.key(key))
+		if err != nil {
+			return err
+		}
+		switch reply := reply.(type) {
+		case []byte:
+			value = reply
+		case nil:
+		case Error:
+			return reply
+		default:
+			return errProtocol
+		}
+		return nil
+	})
+	return value, err == nil && value != nil
+}
+
+// Set stores value under key, expiring it once the transport could no
+// longer use it. A value already past that point is not stored, and any
+// previous value under key is deleted instead.
+func (r *RedisCache) Set(key string, value []byte) {
+	ttl, keep := r.ttl(value)
+	if !keep {
+		r.Delete(key)
+		return
+	}
+	args := [][]byte{[]byte("SET"), r.key(key), value}
+	if ttl > 0 {
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(ttl.Milliseconds(), 10)))
+	}
+	r.with(func(c *conn) error {
+		return expectOK(c.do(args...))
+	})
+}
+
+// Delete removes key.
+func (r *RedisCache) Delete(key string) {
+	r.DeleteMany(key)
+}
+
+// DeleteMany removes every key in one round trip. Each key is deleted by its
+// own pipelined command rather than one multi-key DEL, which a Redis Cluster
+// would refuse for keys in different slots.
+func (r *RedisCache) DeleteMany(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	r.with(func(c *conn) error {
+		for _, key := range keys {
+			c.write([]byte("DEL"), r.key(key))
+		}
+		if err := c.flush(); err != nil {
+			return err
+		}
+		var errs []error
+		for range keys {
+			if err := expectOK(c.read()); err != nil {
+				if c.broken {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// Keys returns the keys stored that start with prefix, found by SCAN so the
+// server is never blocked for long. Keys stored or deleted while it runs may
+// or may not be included. On an error it returns the keys found so far.
+func (r *RedisCache) Keys(prefix string) []string {
+	var keys []string
+	pattern := []byte(globEscape(r.cfg.prefix+prefix) + "*")
+	r.with(func(c *conn) error {
+		cursor := []byte("0")
+		for {
+			reply, err := c.do([]byte("SCAN"), cursor, []byte("MATCH"), pattern, []byte("COUNT"), []byte("1000"))
+			if err != nil {
+				return err
+			}
+			if e, ok := reply.(Error); ok {
+				return e
+			}
+			page, ok := reply.([]any)
+			if !ok || len(page) != 2 {
+				return errProtocol
+			}
+			next, ok := page[0].([]byte)
+			found, ok2 := page[1].([]any)
+			if !ok || !ok2 {
+				return errProtocol
+			}
+			for _, k := range found {
+				if k, ok := k.([]byte); ok {
+					keys = append(keys, string(k[len(r.cfg.prefix):]))
+				}
+			}
+			if string(next) == "0" {
+				return nil
+			}
+			cursor = next
+		}
+	})
+	return keys
+}
+
+// Ping checks that the server can be reached and, with WithAuth, that it
+// accepts the credentials.
+func (r *RedisCache) Ping() error {
+	return r.with(func(c *conn) error {
+		return expectOK(c.do([]byte("PING")))
+	})
+}
+
+// Close closes the idle connections. Connections in use are closed as their
+// operations finish, and operations started after Close fail.
+func (r *RedisCache) Close() error {
+	return r.pool.close()
+}
+
+// with runs fn on a pooled connection and reports any error it returns.
+func (r *RedisCache) with(fn func(c *conn) error) error {
+	c, err := r.pool.get(context.Background())
+	if err == nil {
+		err = fn(c)
+		r.pool.put(c)
+	}
+	if err != nil && r.cfg.onError != nil {
+		r.cfg.onError(err)
+	}
+	return err
+}
+
+// globEscape escapes the characters SCAN's MATCH treats as wildcards.
+func globEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func (r *RedisCache) key(key string) []byte {
+	return []byte(r.cfg.prefix + key)
+}
+
+// ttl returns how long value should be kept, zero meaning indefinitely, and
+// false if it is already past use.
+func (r *RedisCache) ttl(value []byte) (time.Duration, bool) {
+	_, discardAt, ok := httpcache.EntryExpiry(value)
+	if !ok || discardAt.IsZero() {
+		return r.cfg.defaultTTL, true
+	}
+	ttl := discardAt.Sub(r.now())
+	if hasValidators(value) {
+		ttl += r.cfg.staleGrace
+	}
+	if ttl <= 0 {
+		return 0, false
+	}
+	return max(ttl, time.Millisecond), true
+}
+
+// hasValidators reports whether the response in value carries an ETag or
+// Last-Modified, letting the transport revalidate it once stale.
+func hasValidators(value []byte) bool {
+	head, _, _ := bytes.Cut(value, []byte("\r\n\r\n"))
+	head = bytes.ToLower(head)
+	return bytes.Contains(head, []byte("\r\netag:")) || bytes.Contains(head, []byte("\r\nlast-modified:"))
+}
@@ -0,0 +1,352 @@
+package RedisCache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// dumped returns a serialized response dated at epoch, with the given
+// Cache-Control, if any, and extra header lines.
+func dumped(cacheControl string, header ...string) []byte {
+	s := "HTTP/1.1 200 OK\r\nDate: " + epoch.Format(http.TimeFormat) + "\r\n"
+	if cacheControl != "" {
+		header = append(header, "Cache-Control: "+cacheControl)
+	}
+	for _, line := range header {
+		s += line + "\r\n"
+	}
+	return []byte(s + "Content-Length: 2\r\n\r\nok")
+}
+
+func newTestCache(t *testing.T, f *fakeRedis, opts ...Option) *RedisCache {
+	t.Helper()
+	r, err := NewRedisCache(f.addr(), opts...)
+	if err != nil {
+		t.Fatalf("NewRedisCache: %v", err)
+	}
+	r.now = func() time.Time { return epoch }
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestGetSetDelete(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f, WithPrefix("hc:"))
+
+	if _, ok := r.Get("k"); ok {
+		t.Fatal("Get on an empty server reported a hit")
+	}
+	r.Set("k", []byte("v"))
+	if v, ok := r.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("Get = %q, %v, want %q", v, ok, "v")
+	}
+	if _, ok := f.entry("hc:k"); !ok {
+		t.Error("the key was not stored under its prefix")
+	}
+	r.Delete("k")
+	if _, ok := r.Get("k"); ok {
+		t.Error("Get after Delete reported a hit")
+	}
+}
+
+func TestSetExpiresWithFreshnessAndStaleIfError(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f)
+
+	for _, tt := range []struct {
+		cacheControl string
+		want         time.Duration
+	}{
+		{"max-age=60", time.Minute},
+		{"max-age=60, stale-if-error=300", 5 * time.Minute},
+		{"max-age=600, stale-if-error=300", 10 * time.Minute},
+		{"max-age=60, stale-if-error", 0},
+		{"max-age=60, immutable", 0},
+	} {
+		r.Set("k", dumped(tt.cacheControl))
+		e, ok := f.entry("k")
+		if !ok {
+			t.Errorf("%s: not stored", tt.cacheControl)
+			continue
+		}
+		if e.ttl != tt.want {
+			t.Errorf("%s: TTL = %v, want %v", tt.cacheControl, e.ttl, tt.want)
+		}
+	}
+}
+
+func TestSetDefaultTTLAndStaleGrace(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f, WithDefaultTTL(time.Hour), WithStaleGrace(time.Minute))
+
+	r.Set("opaque", []byte("not a response"))
+	if e, _ := f.entry("opaque"); e.ttl != time.Hour {
+		t.Errorf("opaque value TTL = %v, want the default %v", e.ttl, time.Hour)
+	}
+	r.Set("fresh", dumped("max-age=60", `ETag: "v1"`))
+	if e, _ := f.entry("fresh"); e.ttl != 2*time.Minute {
+		t.Errorf("TTL with grace = %v, want %v", e.ttl, 2*time.Minute)
+	}
+	r.Set("no-validators", dumped("max-age=60"))
+	if e, _ := f.entry("no-validators"); e.ttl != time.Minute {
+		t.Errorf("TTL without validators = %v, want no grace, %v", e.ttl, time.Minute)
+	}
+	r.now = func() time.Time { return epoch.Add(90 * time.Second) }
+	r.Set("stale", dumped("max-age=60", "Last-Modified: "+epoch.Format(http.TimeFormat)))
+	if e, _ := f.entry("stale"); e.ttl != 30*time.Second {
+		t.Errorf("stale entry TTL = %v, want what is left of the grace, %v", e.ttl, 30*time.Second)
+	}
+}
+
+// The transport stores a response that is stale on arrival only so it can
+// revalidate it; with the default options it must be kept for that.
+func TestSetKeepsRevalidatableEntries(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f)
+	for _, cacheControl := range []string{"no-cache", "max-age=0", ""} {
+		r.Set("k", dumped(cacheControl, `ETag: "v1"`))
+		if e, ok := f.entry("k"); !ok || e.ttl != DefaultStaleGrace {
+			t.Errorf("Cache-Control %q with an ETag: stored %v with TTL %v, want %v", cacheControl, ok, e.ttl, DefaultStaleGrace)
+		}
+		r.Set("k", dumped(cacheControl))
+		if _, ok := f.entry("k"); ok {
+			t.Errorf("Cache-Control %q without validators was stored", cacheControl)
+		}
+	}
+}
+
+// An entry already past use must not be stored, and must not leave the
+// previous value behind either.
+func TestSetDeletesExpiredEntry(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f)
+	r.Set("k", []byte("old"))
+	r.now = func() time.Time { return epoch.Add(time.Hour) }
+	r.Set("k", dumped("max-age=60"))
+	if _, ok := f.entry("k"); ok {
+		t.Error("an expired entry was stored, or the old value kept")
+	}
+}
+
+func TestKeysFollowsScanCursor(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f, WithPrefix("hc:"))
+	for _, key := range []string{"a*1", "a*2", "a*3", "a*4", "a*5", "ab", "b"} {
+		r.Set(key, []byte("v"))
+	}
+	got := r.Keys("a*")
+	slices.Sort(got)
+	if want := []string{"a*1", "a*2", "a*3", "a*4", "a*5"}; !slices.Equal(got, want) {
+		t.Errorf("Keys(%q) = %q, want %q", "a*", got, want)
+	}
+}
+
+// countingConn counts the writes a connection makes.
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func TestDeleteManyIsOneRoundTrip(t *testing.T) {
+	f := newFakeRedis(t)
+	var writes atomic.Int64
+	dialer := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		return countingConn{nc, &writes}, err
+	}
+	r := newTestCache(t, f, WithDialer(dialer))
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", i)
+		r.Set(keys[i], []byte("v"))
+	}
+	before := writes.Load()
+	r.DeleteMany(keys...)
+	if n := writes.Load() - before; n != 1 {
+		t.Errorf("DeleteMany of %d keys made %d writes, want 1", len(keys), n)
+	}
+	for _, key := range keys {
+		if _, ok := f.entry(key); ok {
+			t.Errorf("%s survived DeleteMany", key)
+		}
+	}
+	// The replies were all consumed: the connection is still in step.
+	r.Set("after", []byte("v"))
+	if v, ok := r.Get("after"); !ok || string(v) != "v" {
+		t.Errorf("Get after DeleteMany = %q, %v", v, ok)
+	}
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f, WithPoolSize(2))
+
+	for i := 0; i < 50; i++ {
+		r.Set("k", []byte("v"))
+		r.Get("k")
+	}
+	if n := f.accepted.Load(); n != 1 {
+		t.Errorf("%d connections for sequential use, want 1", n)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Get("k")
+		}()
+	}
+	wg.Wait()
+	r.pool.mu.Lock()
+	idle := len(r.pool.idle)
+	r.pool.mu.Unlock()
+	if idle > 2 {
+		t.Errorf("%d idle connections kept, want at most 2", idle)
+	}
+}
+
+// countedConn decrements open when it is closed.
+type countedConn struct {
+	net.Conn
+	open *atomic.Int64
+	once sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.open.Add(-1) })
+	return c.Conn.Close()
+}
+
+func TestMaxConnsBoundsOpenConnections(t *testing.T) {
+	f := newFakeRedis(t)
+	var open, peak atomic.Int64
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		n := open.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		return &countedConn{Conn: nc, open: &open}, nil
+	}
+	r := newTestCache(t, f, WithDialer(dial), WithPoolSize(1), WithMaxConns(2))
+	r.Set("k", []byte("v"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := r.Get("k"); !ok || string(v) != "v" {
+				t.Errorf("Get = %q, %v, want %q", v, ok, "v")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := peak.Load(); n > 2 {
+		t.Errorf("%d connections open at once, want at most 2", n)
+	}
+}
+
+func TestBrokenConnectionIsReplaced(t *testing.T) {
+	f := newFakeRedis(t)
+	var errs atomic.Int64
+	r := newTestCache(t, f, WithOnError(func(error) { errs.Add(1) }))
+
+	r.Set("k", []byte("v"))
+	f.dropConnections()
+	if _, ok := r.Get("k"); ok {
+		t.Error("Get on a dropped connection reported a hit")
+	}
+	if errs.Load() == 0 {
+		t.Error("the failure was not reported")
+	}
+	if v, ok := r.Get("k"); !ok || string(v) != "v" {
+		t.Errorf("Get on a fresh connection = %q, %v, want %q", v, ok, "v")
+	}
+}
+
+func TestAuthAndSelect(t *testing.T) {
+	f := newFakeRedis(t)
+	f.requirePassword("secret")
+
+	if err := newTestCache(t, f).Ping(); err == nil {
+		t.Error("Ping without credentials succeeded")
+	}
+	if err := newTestCache(t, f, WithAuth("", "wrong")).Ping(); err == nil {
+		t.Error("Ping with a wrong password succeeded")
+	}
+	r := newTestCache(t, f, WithAuth("default", "secret"), WithDB(3))
+	if err := r.Ping(); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	r.Set("k", []byte("v"))
+	if e, _ := f.entry("k"); e.db != 3 {
+		t.Errorf("stored in database %d, want 3", e.db)
+	}
+}
+
+func TestClosedCacheFails(t *testing.T) {
+	f := newFakeRedis(t)
+	r := newTestCache(t, f)
+	r.Close()
+	if err := r.Ping(); err == nil {
+		t.Error("Ping after Close succeeded")
+	}
+}
+
+func TestNewRedisCacheRejectsEmptyAddress(t *testing.T) {
+	if _, err := NewRedisCache(""); err == nil {
+		t.Error("NewRedisCache accepted an empty address")
+	}
+}
+
+func TestTransportCachesInRedis(t *testing.T) {
+	var hits atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+
+	f := newFakeRedis(t)
+	r := newTestCache(t, f)
+	r.now = time.Now
+	client := httpcache.NewTransport(r).Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("origin saw %d requests, want 1", n)
+	}
+}
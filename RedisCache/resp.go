@@ -0,0 +1,159 @@
+package RedisCache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Error is an error reply from the server, such as WRONGTYPE or NOAUTH.
+type Error string
+
+func (e Error) Error() string { return "RedisCache: server replied: " + string(e) }
+
+var errProtocol = errors.New("RedisCache: malformed reply")
+
+// conn is one connection speaking RESP2. Commands are written to a buffer
+// and only sent by flush, so several can share a round trip.
+type conn struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	timeout time.Duration
+	// broken is set once an I/O or protocol error has left the stream in an
+	// unknown state; the pool closes such a connection instead of reusing it.
+	broken bool
+}
+
+func newConn(nc net.Conn, timeout time.Duration) *conn {
+	return &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: timeout}
+}
+
+// do sends one command and reads its reply.
+func (c *conn) do(args ...[]byte) (any, error) {
+	c.write(args...)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// write buffers a command as an array of bulk strings.
+func (c *conn) write(args ...[]byte) {
+	c.w.WriteByte('*')
+	c.w.WriteString(strconv.Itoa(len(args)))
+	c.w.WriteString("\r\n")
+	for _, arg := range args {
+		c.w.WriteByte('$')
+		c.w.WriteString(strconv.Itoa(len(arg)))
+		c.w.WriteString("\r\n")
+		c.w.Write(arg)
+		c.w.WriteString("\r\n")
+	}
+}
+
+func (c *conn) flush() error {
+	c.deadline()
+	if err := c.w.Flush(); err != nil {
+		c.broken = true
+		return err
+	}
+	return nil
+}
+
+// read returns the next reply: a string for a simple string, an int64, a
+// []byte for a bulk string, nil for a null, a []any for an array, or an
+// Error. Error replies are returned as the value, not as err, so a pipeline
+// can tell a failed command from a failed connection.
+func (c *conn) read() (any, error) {
+	c.deadline()
+	reply, err := c.readReply()
+	if err != nil {
+		c.broken = true
+	}
+	return reply, err
+}
+
+func (c *conn) deadline() {
+	if c.timeout > 0 {
+		c.nc.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+func (c *conn) readReply() (any, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, errProtocol
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("RedisCache: unexpected reply type %q", line[0])
+	}
+}
+
+// readLine returns the next CRLF-terminated line without its terminator.
+func (c *conn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, errProtocol
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *conn) close() error {
+	return c.nc.Close()
+}
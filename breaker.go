@@ -0,0 +1,263 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, wrapped in the usual *url.Error by
+// http.Client, when a request is refused because the circuit for its host is
+// open and nothing cached can stand in for the response.
+var ErrCircuitOpen = errors.New("httpcache: circuit open")
+
+// BreakerState is the state of the circuit for one host.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen refuses requests without dialling.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe through to decide between the two.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker tracks upstream failures per host and stops sending requests
+// to a host that keeps failing.
+//
+// A failure is a round trip that returns an error, other than the caller's
+// own cancellation, or a 500, 502, 503, or 504 response. After Threshold
+// consecutive failures the host's circuit opens: requests that have a cached
+// response behind them are answered from it, marked with Warning 111
+// ("Revalidation failed"), and everything else fails fast with
+// ErrCircuitOpen. Once OpenFor has elapsed the circuit half-opens and lets a
+// single probe through; requests arriving meanwhile wait for its outcome
+// rather than piling onto a host that may still be down. A successful probe
+// closes the circuit and a failed one re-opens it; one its caller cancels
+// decides nothing, and the next request probes again.
+//
+// A CircuitBreaker is safe for concurrent use and may be shared by several
+// Transports.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures that opens a circuit.
+	Threshold int
+	// OpenFor is how long a circuit stays open before it half-opens.
+	OpenFor time.Duration
+	// OnStateChange, if set, is called after a host's circuit changes state.
+	// It is called without any lock held, but may be called concurrently for
+	// different hosts.
+	OnStateChange func(host string, from, to BreakerState)
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+type circuit struct {
+	state    BreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker returns a breaker that opens after threshold consecutive
+// failures and stays open for openFor. It returns nil if threshold is not
+// positive.
+func NewCircuitBreaker(threshold int, openFor time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{Threshold: threshold, OpenFor: openFor}
+}
+
+// State returns the current state of the circuit for host.
+func (b *CircuitBreaker) State(host string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.hosts[host]; ok {
+		return c.state
+	}
+	return BreakerClosed
+}
+
+// admit reports whether a request to host may proceed. It returns
+// BreakerClosed to let it through, BreakerOpen to refuse it, and
+// BreakerHalfOpen when the caller must go through the probe.
+func (b *CircuitBreaker) admit(host string) BreakerState {
+	b.mu.Lock()
+	c := b.circuitLocked(host)
+	from := c.state
+	if c.state == BreakerOpen && time.Since(c.openedAt) >= b.OpenFor {
+		c.state = BreakerHalfOpen
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.notify(host, from, to)
+	return to
+}
+
+// outcome is what a round trip says about the health of its origin.
+type outcome int
+
+const (
+	succeeded outcome = iota
+	failed
+	// unknown is a round trip the caller abandoned: the origin may or may
+	// not have been about to answer.
+	unknown
+)
+
+// record feeds the outcome of a request to host into its circuit. An
+// unknown outcome changes nothing, so a half-open circuit stays half-open
+// and the next request probes again.
+func (b *CircuitBreaker) record(host string, o outcome) {
+	if o == unknown {
+		return
+	}
+	b.mu.Lock()
+	c := b.circuitLocked(host)
+	from := c.state
+	switch {
+	case o == succeeded:
+		c.failures = 0
+		c.state = BreakerClosed
+	case c.state == BreakerHalfOpen:
+		c.state = BreakerOpen
+		c.openedAt = time.Now()
+	case c.state == BreakerClosed:
+		c.failures++
+		if c.failures >= b.Threshold {
+			c.state = BreakerOpen
+			c.openedAt = time.Now()
+		}
+	}
+	to := c.state
+	b.mu.Unlock()
+
+	b.notify(host, from, to)
+}
+
+func (b *CircuitBreaker) circuitLocked(host string) *circuit {
+	if b.hosts == nil {
+		b.hosts = make(map[string]*circuit)
+	}
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{}
+		b.hosts[host] = c
+	}
+	return c
+}
+
+func (b *CircuitBreaker) notify(host string, from, to BreakerState) {
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(host, from, to)
+	}
+}
+
+// upstreamOutcome classifies the outcome of a round trip for the breaker.
+func upstreamOutcome(req *http.Request, resp *http.Response, err error) outcome {
+	if err != nil {
+		if callerGaveUp(req, err) {
+			return unknown
+		}
+		return failed
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return failed
+	}
+	return succeeded
+}
+
+// callerGaveUp reports whether err is the caller abandoning req, which says
+// nothing about the origin's health. http.Client enforces its Timeout by
+// closing req.Cancel on a timer of its own, so the transport may return
+// before the request's context reports the deadline.
+func callerGaveUp(req *http.Request, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	if req.Context().Err() != nil {
+		return true
+	}
+	if req.Cancel != nil {
+		select {
+		case <-req.Cancel:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// probeResult is what a half-open probe hands the callers waiting on it.
+type probeResult struct {
+	req  *http.Request // the leader's request, which was sent as the probe
+	resp *http.Response
+	err  error
+}
+
+// roundTripBreaker sends req to the origin, subject to the circuit breaker if
+// one is configured.
+func (t *Transport) roundTripBreaker(req *http.Request) (*http.Response, error) {
+	b := t.Breaker
+	if b == nil {
+		return t.roundTripper().RoundTrip(req)
+	}
+	host := req.URL.Host
+
+	switch b.admit(host) {
+	case BreakerOpen:
+		return nil, ErrCircuitOpen
+	case BreakerHalfOpen:
+		// The probe rides on the transport's singleflight group, keyed on the
+		// host rather than the request: whichever caller arrives first sends
+		// its own request as the probe, and everyone else waits to learn
+		// whether the host has recovered. Each caller stops waiting when its
+		// own context ends, so a hung probe holds up nobody past their
+		// deadline.
+		ch := t.singleflight.DoChan("\x00breaker-probe\x00"+host, func() (interface{}, error) {
+			resp, err := t.roundTripper().RoundTrip(req)
+			b.record(host, upstreamOutcome(req, resp, err))
+			return &probeResult{req: req, resp: resp, err: err}, nil
+		})
+		select {
+		case res := <-ch:
+			if p := res.Val.(*probeResult); p.req == req {
+				return p.resp, p.err
+			}
+		case <-req.Context().Done():
+			// If this caller led the probe, nobody else will close its
+			// response.
+			go func() {
+				if p := (<-ch).Val.(*probeResult); p.req == req && p.resp != nil {
+					p.resp.Body.Close()
+				}
+			}()
+			return nil, req.Context().Err()
+		}
+		if b.State(host) != BreakerClosed {
+			return nil, ErrCircuitOpen
+		}
+	}
+
+	resp, err := t.roundTripper().RoundTrip(req)
+	b.record(host, upstreamOutcome(req, resp, err))
+	return resp, err
+}
@@ -0,0 +1,293 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyOrigin serves a stale-on-arrival cacheable body while healthy and 503
+// otherwise, counting every request that reaches it.
+type flakyOrigin struct {
+	hits    int64
+	failing atomic.Bool
+	delay   time.Duration
+
+	mu       sync.Mutex
+	arrivals []time.Time
+}
+
+func (o *flakyOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&o.hits, 1)
+	o.mu.Lock()
+	o.arrivals = append(o.arrivals, time.Now())
+	o.mu.Unlock()
+	time.Sleep(o.delay)
+	if o.failing.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=0")
+	w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+	fmt.Fprint(w, "healthy")
+}
+
+func get(t *testing.T, client *http.Client, u string) (*http.Response, string, error) {
+	t.Helper()
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, "", err
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp, string(b), nil
+}
+
+func TestBreakerOpensAndServesStale(t *testing.T) {
+	o := &flakyOrigin{}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+
+	b := NewCircuitBreaker(3, time.Hour)
+	client := NewTransport(newTestCache(), WithCircuitBreaker(b)).Client()
+
+	if _, _, err := get(t, client, srv.URL+"/cached"); err != nil {
+		t.Fatal(err)
+	}
+
+	o.failing.Store(true)
+	for i := 0; i < 3; i++ {
+		if _, _, err := get(t, client, srv.URL+"/other"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host := srv.Listener.Addr().String()
+	if got := b.State(host); got != BreakerOpen {
+		t.Fatalf("state after 3 failures = %v, want open", got)
+	}
+	before := atomic.LoadInt64(&o.hits)
+
+	resp, body, err := get(t, client, srv.URL+"/cached")
+	if err != nil {
+		t.Fatalf("open circuit with a cached entry: %v", err)
+	}
+	if body != "healthy" || !hasWarning(resp, "111") {
+		t.Errorf("got %q with Warning %q, want the cached body with 111", body, resp.Header.Values("Warning"))
+	}
+
+	_, _, err = get(t, client, srv.URL+"/uncached")
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("open circuit without a cached entry: err = %v, want ErrCircuitOpen", err)
+	}
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Errorf("err = %T, want it wrapped in *url.Error by the client", err)
+	}
+
+	if got := atomic.LoadInt64(&o.hits); got != before {
+		t.Errorf("origin saw %d requests while the circuit was open, want 0", got-before)
+	}
+}
+
+// Once OpenFor elapses exactly one request goes upstream, however many
+// arrive together, and its success closes the circuit for everyone.
+func TestBreakerHalfOpenSendsSingleProbe(t *testing.T) {
+	o := &flakyOrigin{delay: 100 * time.Millisecond}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var transitions []string
+	b := NewCircuitBreaker(1, 50*time.Millisecond)
+	b.OnStateChange = func(host string, from, to BreakerState) {
+		mu.Lock()
+		defer mu.Unlock()
+		transitions = append(transitions, from.String()+"->"+to.String())
+	}
+	client := NewTransport(newTestCache(), WithCircuitBreaker(b)).Client()
+
+	o.failing.Store(true)
+	if _, _, err := get(t, client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	o.failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	before := atomic.LoadInt64(&o.hits)
+
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Distinct paths, so revalidation dedup cannot be what collapses
+			// the requests.
+			if _, _, err := get(t, client, fmt.Sprintf("%s/p%d", srv.URL, i)); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// One probe, then the n-1 followers proceed against a closed circuit.
+	if got := atomic.LoadInt64(&o.hits) - before; got != n {
+		t.Fatalf("origin saw %d requests, want %d", got, n)
+	}
+	o.mu.Lock()
+	arrivals := o.arrivals[len(o.arrivals)-n:]
+	o.mu.Unlock()
+	if gap := arrivals[1].Sub(arrivals[0]); gap < o.delay {
+		t.Errorf("second request arrived %v after the probe, want at least %v: followers did not wait", gap, o.delay)
+	}
+	if got := b.State(srv.Listener.Addr().String()); got != BreakerClosed {
+		t.Errorf("state after a successful probe = %v, want closed", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if fmt.Sprint(transitions) != fmt.Sprint(want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestBreakerFailedProbeReopens(t *testing.T) {
+	b := NewCircuitBreaker(1, 0)
+	b.record("h", failed)
+	if got := b.admit("h"); got != BreakerHalfOpen {
+		t.Fatalf("admit after OpenFor = %v, want half-open", got)
+	}
+	b.record("h", failed)
+	if got := b.State("h"); got != BreakerOpen {
+		t.Errorf("state after a failed probe = %v, want open", got)
+	}
+}
+
+func TestBreakerIgnoresCallerCancellation(t *testing.T) {
+	b := NewCircuitBreaker(1, time.Hour)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	client := NewTransport(newTestCache(), WithCircuitBreaker(b)).Client()
+	client.Timeout = 20 * time.Millisecond
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if got := b.State(srv.Listener.Addr().String()); got != BreakerClosed {
+		t.Errorf("state after the caller timed out = %v, want closed", got)
+	}
+}
+
+// hangingOrigin reports each request on arrived and holds it until release
+// is closed.
+func hangingOrigin(t *testing.T, arrived chan<- string, release <-chan struct{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- r.URL.Path
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBreakerCancelledProbeDoesNotClose(t *testing.T) {
+	arrived, release := make(chan string, 1), make(chan struct{})
+	srv := hangingOrigin(t, arrived, release)
+	defer close(release)
+	host := srv.Listener.Addr().String()
+	b := NewCircuitBreaker(1, 0)
+	b.record(host, failed)
+	tr := NewTransport(newTestCache(), WithCircuitBreaker(b))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := tr.RoundTrip(req)
+		done <- err
+	}()
+	<-arrived
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatal("expected the cancelled probe to fail")
+	}
+	if got := b.State(host); got != BreakerHalfOpen {
+		t.Errorf("state after the probe's caller cancelled = %v, want half-open", got)
+	}
+}
+
+func TestBreakerFollowerHonoursItsOwnDeadline(t *testing.T) {
+	arrived, release := make(chan string, 1), make(chan struct{})
+	srv := hangingOrigin(t, arrived, release)
+	defer close(release)
+	b := NewCircuitBreaker(1, 0)
+	b.record(srv.Listener.Addr().String(), failed)
+	tr := NewTransport(newTestCache(), WithCircuitBreaker(b))
+
+	probe, _ := http.NewRequest("GET", srv.URL+"/probe", nil)
+	go func() {
+		if resp, err := tr.RoundTrip(probe); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-arrived
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/other", nil)
+	start := time.Now()
+	if _, err := tr.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("follower waited %v behind a hung probe", elapsed)
+	}
+}
+
+func TestUpstreamOutcomeOfCallerGivingUp(t *testing.T) {
+	timedOut := make(chan struct{})
+	close(timedOut)
+	cases := []struct {
+		name   string
+		cancel chan struct{}
+		err    error
+		want   outcome
+	}{
+		{"origin error", nil, errors.New("connection reset"), failed},
+		{"context deadline", nil, fmt.Errorf("read: %w", context.DeadlineExceeded), unknown},
+		{"context cancelled", nil, context.Canceled, unknown},
+		{"client timeout through Request.Cancel", timedOut, errors.New("net/http: request canceled"), unknown},
+	}
+	for _, tc := range cases {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Cancel = tc.cancel
+		if got := upstreamOutcome(req, nil, tc.err); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBreakerCancellationKeepsFailureStreak(t *testing.T) {
+	b := NewCircuitBreaker(2, time.Hour)
+	b.record("h", failed)
+	b.record("h", unknown)
+	b.record("h", failed)
+	if got := b.State("h"); got != BreakerOpen {
+		t.Errorf("state after failed, cancelled, failed = %v, want open", got)
+	}
+}
+
+func TestNewCircuitBreakerRejectsNonPositiveThreshold(t *testing.T) {
+	if b := NewCircuitBreaker(0, time.Second); b != nil {
+		t.Errorf("NewCircuitBreaker(0) = %v, want nil", b)
+	}
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// DefaultCacheableStatuses are the status codes a Transport stores when its
+// CacheableStatuses is nil: those RFC 9110 section 15.1 makes cacheable by
+// default, less 206, since partial content is never stored. Anything else,
+// a 500 in particular, is passed through and not stored.
+var DefaultCacheableStatuses = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusMultipleChoices,
+	http.StatusMovedPermanently,
+	http.StatusPermanentRedirect,
+	http.StatusNotFound,
+	http.StatusMethodNotAllowed,
+	http.StatusGone,
+	http.StatusRequestURITooLong,
+	http.StatusNotImplemented,
+}
+
+// MaxQueryBodyBytes is the largest POST body CachePOST will key a request
+// on. A request with a larger body is sent upstream as usual and not cached.
+const MaxQueryBodyBytes = 1 << 20 // 1 MiB
+
+// storableStatus reports whether a response with this status may be stored.
+func (t *Transport) storableStatus(code int) bool {
+	statuses := t.CacheableStatuses
+	if statuses == nil {
+		statuses = DefaultCacheableStatuses
+	}
+	return slices.Contains(statuses, code)
+}
+
+// storable reports whether resp, the answer to a cacheable req, may be
+// stored. A POST response must state its own lifetime: RFC 9111 section 3
+// lets a cache reuse one only then, and it keeps a Rule's TTL, which sets
+// max-age, the way to opt an endpoint in.
+func (t *Transport) storable(req *http.Request, resp *http.Response) bool {
+	if !canStore(parseCacheControl(req.Header), parseCacheControl(resp.Header)) {
+		return false
+	}
+	if !t.storableStatus(resp.StatusCode) {
+		return false
+	}
+	if req.Method == http.MethodPost {
+		return parseCacheControl(resp.Header).Have("max-age") || resp.Header.Get("Expires") != ""
+	}
+	return true
+}
+
+// queryBody reads the body of a POST so the request can be keyed on it. It
+// returns a clone of req whose body replays what was read, and the hex
+// SHA-256 digest of the body, or "" if the body is larger than
+// MaxQueryBodyBytes, in which case the clone streams it whole and the
+// request is not cached. req's own body is consumed either way.
+func queryBody(req *http.Request) (*http.Request, string, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		sum := sha256.Sum256(nil)
+		return req, hex.EncodeToString(sum[:]), nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, MaxQueryBodyBytes+1))
+	if err != nil {
+		req.Body.Close()
+		return nil, "", err
+	}
+	clone := req.Clone(req.Context())
+	if len(body) > MaxQueryBodyBytes {
+		clone.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return clone, "", nil
+	}
+	req.Body.Close()
+	// Anything re-sending the request, a redirect or a refresh-ahead, needs
+	// the body again, and the caller's has been read.
+	clone.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	clone.Body, _ = clone.GetBody()
+	clone.ContentLength = int64(len(body))
+	sum := sha256.Sum256(body)
+	return clone, hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,206 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOnlyCacheableStatusesAreStored(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   int
+		opts     []CacheOption
+		wantHits int64
+	}{
+		{"200 by default", http.StatusOK, nil, 1},
+		{"404 by default", http.StatusNotFound, nil, 1},
+		{"500 never by default", http.StatusInternalServerError, nil, 2},
+		{"302 not by default", http.StatusFound, nil, 2},
+		{"404 left out of the configured list", http.StatusNotFound, []CacheOption{WithCacheableStatuses(http.StatusOK)}, 2},
+		{"302 added to the configured list", http.StatusFound, []CacheOption{WithCacheableStatuses(http.StatusOK, http.StatusFound)}, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var hits int64
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&hits, 1)
+				w.Header().Set("Cache-Control", "max-age=60")
+				w.Header().Set("Location", "/elsewhere")
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			tr := NewTransport(newTestCache(), tc.opts...)
+			for range 2 {
+				if resp := fetch(t, tr, srv.URL, nil); resp.StatusCode != tc.status {
+					t.Fatalf("got status %d, want %d", resp.StatusCode, tc.status)
+				}
+			}
+			if got := atomic.LoadInt64(&hits); got != tc.wantHits {
+				t.Errorf("upstream hits = %d, want %d", got, tc.wantHits)
+			}
+		})
+	}
+}
+
+// queryOrigin echoes POST bodies, counting requests, and reports the last
+// If-None-Match it saw.
+func queryOrigin(t *testing.T, hits *int64, cacheControl string, lastINM *atomic.Value) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(hits, 1)
+		if lastINM != nil {
+			lastINM.Store(r.Header.Get("If-None-Match"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("result:" + string(body)))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(t *testing.T, tr *Transport, url, body string) string {
+	t.Helper()
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestPOSTCachingKeysOnBody(t *testing.T) {
+	var hits int64
+	srv := queryOrigin(t, &hits, "max-age=60", nil)
+	tr := NewTransport(newTestCache(), WithPOSTCaching(true))
+
+	for _, q := range []string{`{"q":"a"}`, `{"q":"b"}`, `{"q":"a"}`, `{"q":"b"}`} {
+		defer func() { t.Logf("hits=%d", atomic.LoadInt64(&hits)) }()
+		if got, want := post(t, tr, srv.URL, q), "result:"+q; got != want {
+			t.Errorf("query %s: got %q, want %q", q, got, want)
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2, one per distinct body", got)
+	}
+}
+
+func TestPOSTNotCachedByDefault(t *testing.T) {
+	var hits int64
+	srv := queryOrigin(t, &hits, "max-age=60", nil)
+	tr := NewTransport(newTestCache())
+	post(t, tr, srv.URL, "q")
+	post(t, tr, srv.URL, "q")
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+}
+
+func TestPOSTWithoutExplicitLifetimeIsNotStored(t *testing.T) {
+	var hits int64
+	srv := queryOrigin(t, &hits, "", nil)
+	c := newTestCache()
+	tr := NewTransport(c, WithPOSTCaching(true))
+	post(t, tr, srv.URL, "q")
+	post(t, tr, srv.URL, "q")
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+	if n := c.len(); n != 0 {
+		t.Errorf("cache holds %d entries, want 0", n)
+	}
+}
+
+func TestOversizedPOSTBodyIsSentWhole(t *testing.T) {
+	var hits int64
+	srv := queryOrigin(t, &hits, "max-age=60", nil)
+	tr := NewTransport(newTestCache(), WithPOSTCaching(true), WithMaxCacheableBytes(-1))
+	q := strings.Repeat("q", MaxQueryBodyBytes+1)
+	for range 2 {
+		got := post(t, tr, srv.URL, q)
+		if want := "result:" + q; got != want {
+			t.Fatalf("got a %d-byte response, want %d bytes", len(got), len(want))
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2; an oversized body was cached", got)
+	}
+}
+
+func TestStalePOSTIsRefetchedWithoutValidators(t *testing.T) {
+	var hits int64
+	var lastINM atomic.Value
+	srv := queryOrigin(t, &hits, "max-age=0", &lastINM)
+	tr := NewTransport(newTestCache(), WithPOSTCaching(true))
+	for i := range 2 {
+		if got, want := post(t, tr, srv.URL, "q0"), "result:q0"; got != want {
+			t.Fatalf("request %d: got %q, want %q", i, got, want)
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+	if inm := lastINM.Load(); inm != "" {
+		t.Errorf("stale POST was sent with If-None-Match %q", inm)
+	}
+}
+
+// echoRoundTripper answers in process with max-age=0: "ok" to the first
+// request, then the request body it read. Unlike http.Transport it reads
+// req.Body as given, so a body an earlier attempt consumed arrives empty.
+type echoRoundTripper struct {
+	hits int64
+}
+
+func (e *echoRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if atomic.AddInt64(&e.hits, 1) == 1 {
+		body = []byte("ok")
+	} else {
+		body = append([]byte("result:"), body...)
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Cache-Control": {"max-age=0"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// A stale POST whose revalidation answer is too large to share is sent again
+// on its own, and that second attempt must carry the body too.
+func TestPOSTRetryAfterOversizedRevalidationKeepsBody(t *testing.T) {
+	tr := NewTransport(newTestCache(), WithPOSTCaching(true), WithMaxCacheableBytes(16))
+	tr.Transport = &echoRoundTripper{}
+	q := strings.Repeat("q", 32)
+	if got := post(t, tr, "http://example.com/search", q); got != "ok" {
+		t.Fatalf("first response: got %q, want %q", got, "ok")
+	}
+	if got, want := post(t, tr, "http://example.com/search", q), "result:"+q; got != want {
+		t.Errorf("retried POST: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,406 @@
+package httpcache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// DefaultCoalesceWindowBytes is the window applied when a Transport leaves
+// CoalesceWindowBytes at zero.
+const DefaultCoalesceWindowBytes = 1 << 20 // 1 MiB
+
+// errCoalesceDiverged is returned by a detached reader whose own fetch could
+// not be shown to be the same representation it had already been streaming.
+var errCoalesceDiverged = errors.New("httpcache: representation changed while a lagging reader caught up")
+
+// coalesceWindowBytes resolves the configured window.
+func (t *Transport) coalesceWindowBytes() int64 {
+	if t.CoalesceWindowBytes <= 0 {
+		return DefaultCoalesceWindowBytes
+	}
+	return t.CoalesceWindowBytes
+}
+
+// fanout is one upstream response shared by every request that missed the
+// cache for the same flight key while it was in progress.
+//
+// A pump goroutine reads the upstream body into buf, which holds the bytes
+// from offset start to head. Each reader keeps its own offset; base is the
+// lowest of them. The pump never lets head run more than window bytes past
+// base, which is the back-pressure: memory stays bounded no matter how fast
+// the origin is. When the window is full and some readers are ahead of the
+// others, the ones pinning base are detached and catch up with a fetch of
+// their own, so one slow caller cannot stall the rest.
+type fanout struct {
+	t      *Transport
+	ready  chan struct{} // closed once resp or hdrErr is set
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	cond    sync.Cond
+	resp    *http.Response
+	hdrErr  error
+	window  int64
+	buf     []byte
+	start   int64
+	base    int64
+	readers map[*fanoutReader]struct{}
+	// abandoned is set once the last reader leaves; the fetch is being torn
+	// down and nobody new may join it.
+	abandoned bool
+	done      bool  // the pump has stopped; err says why
+	err       error // io.EOF on a complete body
+	trailer   http.Header
+	// Snapshotted before the pump starts, because net/http fills the
+	// upstream Trailer in while the pump reads.
+	trailerKeys []string
+}
+
+// coalesce performs req, sharing one upstream response with any identical
+// request that misses the cache while it is in flight. follower reports
+// whether this caller joined a fetch somebody else started; only the caller
+// that started it stores the result.
+func (t *Transport) coalesce(key string, req *http.Request) (resp *http.Response, follower bool, err error) {
+	fk := flightKey(key, req)
+
+	t.flightsMu.Lock()
+	f := t.flights[fk]
+	var r *fanoutReader
+	if f != nil {
+		r = f.join(req)
+	}
+	if r == nil {
+		f = t.startFanout(fk, req)
+		r = f.join(req)
+		follower = false
+	} else {
+		follower = true
+	}
+	t.flightsMu.Unlock()
+
+	select {
+	case <-f.ready:
+	case <-req.Context().Done():
+		r.Close()
+		return nil, false, req.Context().Err()
+	}
+	if f.hdrErr != nil {
+		r.Close()
+		return nil, false, f.hdrErr
+	}
+	return f.response(r), follower, nil
+}
+
+// startFanout registers a new fanout under fk and begins its upstream fetch.
+// The caller must hold t.flightsMu.
+//
+// The fetch runs under a context detached from the caller that started it,
+// so that caller giving up does not cut off everyone who joined; it is
+// cancelled once the last reader leaves.
+func (t *Transport) startFanout(fk string, req *http.Request) *fanout {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+	f := &fanout{
+		t:       t,
+		ready:   make(chan struct{}),
+		cancel:  cancel,
+		window:  t.coalesceWindowBytes(),
+		readers: make(map[*fanoutReader]struct{}),
+	}
+	f.cond.L = &f.mu
+	if t.flights == nil {
+		t.flights = make(map[string]*fanout)
+	}
+	t.flights[fk] = f
+
+	shared := req.Clone(ctx)
+	go func() {
+		defer t.forgetFanout(fk, f)
+		resp, err := t.upstream(shared)
+
+		f.mu.Lock()
+		if err != nil {
+			f.hdrErr = err
+			f.done = true
+		} else {
+			f.resp = resp
+			for k := range resp.Trailer {
+				f.trailerKeys = append(f.trailerKeys, k)
+			}
+		}
+		f.mu.Unlock()
+		close(f.ready)
+
+		if err != nil {
+			cancel()
+			return
+		}
+		body := resp.Body
+		if body == nil {
+			body = http.NoBody
+		}
+		f.pump(body)
+	}()
+	return f
+}
+
+// forgetFanout removes f from the flight table, unless a newer fanout has
+// already replaced it.
+func (t *Transport) forgetFanout(fk string, f *fanout) {
+	t.flightsMu.Lock()
+	defer t.flightsMu.Unlock()
+	if t.flights[fk] == f {
+		delete(t.flights, fk)
+	}
+}
+
+// join attaches a new reader at offset zero, or returns nil if the bytes from
+// the start of the body are no longer all held.
+func (f *fanout) join(req *http.Request) *fanoutReader {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.abandoned || f.start != 0 || f.hdrErr != nil || f.head() >= f.window {
+		return nil
+	}
+	if f.done && f.err != io.EOF {
+		return nil
+	}
+	r := &fanoutReader{f: f, req: req}
+	f.readers[r] = struct{}{}
+	f.advanceLocked()
+	r.stopWake = context.AfterFunc(req.Context(), func() {
+		f.mu.Lock()
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	})
+	return r
+}
+
+// response builds the caller's own *http.Response around r. The header is
+// cloned so the caller may modify it freely.
+func (f *fanout) response(r *fanoutReader) *http.Response {
+	up := f.resp
+	resp := &http.Response{
+		Status:           up.Status,
+		StatusCode:       up.StatusCode,
+		Proto:            up.Proto,
+		ProtoMajor:       up.ProtoMajor,
+		ProtoMinor:       up.ProtoMinor,
+		Header:           up.Header.Clone(),
+		ContentLength:    up.ContentLength,
+		TransferEncoding: slices.Clone(up.TransferEncoding),
+		Uncompressed:     up.Uncompressed,
+		TLS:              up.TLS,
+		Request:          r.req,
+		Body:             r,
+	}
+	if len(f.trailerKeys) > 0 {
+		resp.Trailer = make(http.Header, len(f.trailerKeys))
+		for _, k := range f.trailerKeys {
+			resp.Trailer[k] = nil
+		}
+	}
+	r.resp = resp
+	return resp
+}
+
+func (f *fanout) head() int64 {
+	return f.start + int64(len(f.buf))
+}
+
+// advanceLocked recomputes base after a reader moved or left, releases the
+// prefix nobody needs any more, and wakes the pump and any waiting readers.
+func (f *fanout) advanceLocked() {
+	base := f.head()
+	for r := range f.readers {
+		base = min(base, r.off)
+	}
+	f.base = base
+	// Compact only once the dead prefix is at least half the buffer, so the
+	// copy is amortised over many reads rather than paid on each one.
+	if dead := base - f.start; dead > 0 && dead >= int64(len(f.buf))/2 {
+		n := copy(f.buf, f.buf[dead:])
+		f.buf = f.buf[:n]
+		f.start = base
+	}
+	if len(f.readers) == 0 {
+		f.abandoned = true
+		f.cancel()
+	}
+	f.cond.Broadcast()
+}
+
+// detachLaggardsLocked detaches every reader pinning base, provided at least
+// one reader is ahead of them. It reports whether any reader was detached.
+// When all readers sit at base together there is nobody to let go ahead, so
+// the pump waits for them instead.
+func (f *fanout) detachLaggardsLocked() bool {
+	var laggards []*fanoutReader
+	for r := range f.readers {
+		if r.off == f.base {
+			laggards = append(laggards, r)
+		}
+	}
+	if len(laggards) == len(f.readers) {
+		return false
+	}
+	for _, r := range laggards {
+		r.detached = true
+		delete(f.readers, r)
+	}
+	f.advanceLocked()
+	return true
+}
+
+// pump copies the upstream body into the shared buffer until EOF, an error,
+// or every reader leaving.
+func (f *fanout) pump(body io.ReadCloser) {
+	defer body.Close()
+	defer f.cancel()
+
+	chunk := make([]byte, min(32<<10, f.window))
+	for {
+		f.mu.Lock()
+		for len(f.readers) > 0 && f.head()-f.base >= f.window {
+			if !f.detachLaggardsLocked() {
+				f.cond.Wait()
+			}
+		}
+		if len(f.readers) == 0 {
+			f.done, f.err = true, context.Canceled
+			f.mu.Unlock()
+			return
+		}
+		room := f.window - (f.head() - f.base)
+		f.mu.Unlock()
+
+		n, err := body.Read(chunk[:min(int64(len(chunk)), room)])
+
+		f.mu.Lock()
+		f.buf = append(f.buf, chunk[:n]...)
+		if err != nil {
+			f.done, f.err = true, err
+			if err == io.EOF {
+				f.trailer = f.resp.Trailer.Clone()
+			}
+		}
+		f.cond.Broadcast()
+		f.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// fanoutReader is one caller's view of a fanout.
+type fanoutReader struct {
+	f        *fanout
+	req      *http.Request
+	resp     *http.Response
+	off      int64
+	detached bool
+	closed   bool
+	stopWake func() bool
+	// own is this reader's private fetch, once it has been detached.
+	own io.ReadCloser
+}
+
+func (r *fanoutReader) Read(p []byte) (int, error) {
+	if r.own != nil {
+		return r.own.Read(p)
+	}
+	f := r.f
+	f.mu.Lock()
+	for {
+		switch {
+		case r.closed:
+			f.mu.Unlock()
+			return 0, errors.New("httpcache: read on closed response body")
+		case r.detached:
+			f.mu.Unlock()
+			if err := r.catchUp(); err != nil {
+				return 0, err
+			}
+			return r.own.Read(p)
+		case r.off < f.head():
+			n := copy(p, f.buf[r.off-f.start:])
+			r.off += int64(n)
+			f.advanceLocked()
+			f.mu.Unlock()
+			return n, nil
+		case f.done:
+			err := f.err
+			if err == io.EOF && r.resp != nil && r.resp.Trailer != nil {
+				for k, v := range f.trailer {
+					r.resp.Trailer[k] = v
+				}
+			}
+			f.mu.Unlock()
+			return 0, err
+		case r.req.Context().Err() != nil:
+			f.mu.Unlock()
+			return 0, r.req.Context().Err()
+		}
+		f.cond.Wait()
+	}
+}
+
+// catchUp replaces the shared stream with a fetch of this reader's own,
+// skipping the bytes it has already delivered. Splicing two fetches together
+// is only sound if both are the same representation, so a validator the
+// original carried must match; with none to compare, a reader that has
+// already delivered bytes cannot continue.
+func (r *fanoutReader) catchUp() error {
+	up := r.f.resp
+	resp, err := r.f.t.upstream(r.req.Clone(r.req.Context()))
+	if err != nil {
+		return err
+	}
+	same := resp.StatusCode == up.StatusCode
+	switch etag, lastModified := up.Header.Get("Etag"), up.Header.Get("Last-Modified"); {
+	case etag != "":
+		same = same && resp.Header.Get("Etag") == etag
+	case lastModified != "":
+		same = same && resp.Header.Get("Last-Modified") == lastModified
+	default:
+		same = same && r.off == 0
+	}
+	body := resp.Body
+	if body == nil {
+		body = http.NoBody
+	}
+	if !same {
+		body.Close()
+		return errCoalesceDiverged
+	}
+	if _, err := io.CopyN(io.Discard, body, r.off); err != nil {
+		body.Close()
+		if err == io.EOF {
+			return errCoalesceDiverged
+		}
+		return err
+	}
+	r.own = body
+	return nil
+}
+
+func (r *fanoutReader) Close() error {
+	f := r.f
+	f.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		r.stopWake()
+		if !r.detached {
+			delete(f.readers, r)
+			f.advanceLocked()
+		}
+	}
+	f.mu.Unlock()
+	if r.own != nil {
+		return r.own.Close()
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Concurrent cold misses share one upstream request and each caller still
+// gets the whole body; the response is then cached once.
+func TestCoalescedMissesShareOneFetch(t *testing.T) {
+	const body = "PAYLOAD-1234567890"
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(200 * time.Millisecond) // hold the flight open
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	c := newTestCache()
+	client := NewTransport(c, WithMissCoalescing(true)).Client()
+
+	const n = 8
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, b, err := get(t, client, srv.URL)
+			if err != nil {
+				t.Errorf("caller %d: %v", i, err)
+			}
+			bodies[i] = b
+		}(i)
+	}
+	wg.Wait()
+
+	for i, b := range bodies {
+		if b != body {
+			t.Errorf("caller %d body = %q, want %q", i, b, body)
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1", got)
+	}
+	if c.len() != 1 {
+		t.Errorf("cache holds %d entries, want 1", c.len())
+	}
+}
+
+// Followers must see bytes as the origin sends them, not after it finishes.
+func TestCoalescedFollowersStream(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "FIRST")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "LAST")
+	}))
+	defer srv.Close()
+
+	client := NewTransport(newTestCache(), WithMissCoalescing(true)).Client()
+
+	const n = 3
+	firsts := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				firsts <- ""
+				return
+			}
+			defer resp.Body.Close()
+			buf := make([]byte, len("FIRST"))
+			io.ReadFull(resp.Body, buf)
+			firsts <- string(buf)
+			rest, _ := io.ReadAll(resp.Body)
+			if got := string(buf) + string(rest); got != "FIRSTLAST" {
+				t.Errorf("body = %q, want %q", got, "FIRSTLAST")
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		select {
+		case got := <-firsts:
+			if got != "FIRST" {
+				t.Errorf("first chunk = %q", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("a caller did not receive the first chunk before the origin finished")
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+// A caller that falls a window behind is detached and finishes from its own
+// fetch, while the others are never held back by it.
+func TestCoalescedLaggardDetaches(t *testing.T) {
+	const window = 4 << 10
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4*window/16)
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Etag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	client := NewTransport(newTestCache(), WithMissCoalescing(true), WithCoalesceWindowBytes(window)).Client()
+
+	slowStarted := make(chan struct{})
+	fastDone := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { // the laggard: reads a little, then stalls
+		defer wg.Done()
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Error(err)
+			close(slowStarted)
+			return
+		}
+		defer resp.Body.Close()
+		head := make([]byte, 10)
+		io.ReadFull(resp.Body, head)
+		close(slowStarted)
+		<-fastDone
+		rest, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Errorf("laggard: %v", err)
+		}
+		if !bytes.Equal(append(head, rest...), payload) {
+			t.Errorf("laggard body corrupted: %d bytes", len(head)+len(rest))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		defer close(fastDone)
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		<-slowStarted
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || !bytes.Equal(b, payload) {
+			t.Errorf("fast reader: %d bytes, err %v", len(b), err)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fast reader was held back by the laggard")
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2 (one shared, one for the detached laggard)", got)
+	}
+}
+
+// Without a validator, a detached reader that already delivered bytes cannot
+// prove its own fetch is the same body, and must fail rather than splice.
+func TestCoalescedLaggardWithoutValidatorFails(t *testing.T) {
+	const window = 1 << 10
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Write(bytes.Repeat([]byte("z"), 8*window))
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(newTestCache(), WithMissCoalescing(true), WithCoalesceWindowBytes(window))
+	var wg sync.WaitGroup
+	resps := make([]*http.Response, 2)
+	for i := range resps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resps[i] = resp
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	defer resps[0].Body.Close()
+	defer resps[1].Body.Close()
+
+	io.ReadFull(resps[0].Body, make([]byte, 1))
+	if _, err := io.ReadAll(resps[1].Body); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(resps[0].Body); err != errCoalesceDiverged {
+		t.Errorf("laggard err = %v, want errCoalesceDiverged", err)
+	}
+}
+
+// The caller that started the fetch giving up must not cut off the others.
+func TestCoalescedCreatorCancellationDoesNotAffectFollowers(t *testing.T) {
+	const body = "survives"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(newTestCache(), WithMissCoalescing(true))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	creatorErr := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		_, err := tr.RoundTrip(req)
+		creatorErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // let the creator start the flight
+
+	followerBody := make(chan string, 1)
+	go func() {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Error(err)
+			followerBody <- ""
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		followerBody <- string(b)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-creatorErr; err == nil {
+		t.Error("cancelled creator got a response")
+	}
+	if got := <-followerBody; got != body {
+		t.Errorf("follower body = %q, want %q", got, body)
+	}
+}
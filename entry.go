@@ -0,0 +1,111 @@
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net/http"
+	"time"
+)
+
+// Every entry the transport stores is framed as
+//
+//	magic (3) | version (1) | CRC-32C of the response (4) | response
+//
+// where the response is the wire form produced by httputil.DumpResponse. The
+// checksum lets a read notice a backend that handed back damaged bytes — a
+// torn write, a buffer reused under it — before they are parsed and served
+// as a response.
+//
+// The magic starts with a zero byte, which a dumped response never does, so
+// entries written before framing was introduced still read back: anything
+// starting "HTTP/" is taken as an unframed legacy entry.
+var entryMagic = []byte{0, 'h', 'c'}
+
+const (
+	entryVersion    = 1
+	entryHeaderSize = 3 + 1 + 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// errCorruptEntry reports a stored entry that failed its integrity check.
+var errCorruptEntry = errors.New("httpcache: corrupt cache entry")
+
+// frameEntry returns response framed for storage.
+func frameEntry(response []byte) []byte {
+	b := make([]byte, entryHeaderSize, entryHeaderSize+len(response))
+	copy(b, entryMagic)
+	b[3] = entryVersion
+	binary.BigEndian.PutUint32(b[4:entryHeaderSize], crc32.Checksum(response, castagnoli))
+	return append(b, response...)
+}
+
+// unframeEntry verifies a stored entry and returns the response inside it.
+func unframeEntry(entry []byte) ([]byte, error) {
+	if !bytes.HasPrefix(entry, entryMagic) {
+		if bytes.HasPrefix(entry, []byte("HTTP/")) {
+			return entry, nil
+		}
+		return nil, errCorruptEntry
+	}
+	if len(entry) < entryHeaderSize || entry[3] != entryVersion {
+		return nil, errCorruptEntry
+	}
+	response := entry[entryHeaderSize:]
+	if crc32.Checksum(response, castagnoli) != binary.BigEndian.Uint32(entry[4:entryHeaderSize]) {
+		return nil, errCorruptEntry
+	}
+	return response, nil
+}
+
+// EntryExpiry reads the caching headers of a value the Transport stored, for
+// Cache implementations that want to evict or expire by HTTP freshness. It
+// reports when the response goes stale, and when it is past use even as a
+// stale-if-error fallback; a zero discardAt means stale-if-error allows it
+// indefinitely. Request directives are unknown at this point, so only the
+// response's own headers count.
+//
+// It reports false for an entry with no expiry to act on — an immutable
+// response, or one without a Date — and for a value it cannot read.
+//
+// A stale entry is not worthless: the transport revalidates it, and a 304
+// is cheaper than a full response. Dropping entries at staleAt trades that
+// for room.
+func EntryExpiry(value []byte) (staleAt, discardAt time.Time, ok bool) {
+	response, err := unframeEntry(value)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response)), nil)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	respCacheControl := parseCacheControl(resp.Header)
+	noCache := respCacheControl.Have("no-cache")
+	if respCacheControl.Have("immutable") && !noCache {
+		return time.Time{}, time.Time{}, false
+	}
+	date, err := Date(resp.Header)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	staleAt = date
+	if !noCache {
+		staleAt = date.Add(max(0, freshnessLifetime(respCacheControl, resp.Header, date)))
+	}
+	discardAt = staleAt
+	if v, ok := respCacheControl["stale-if-error"]; ok {
+		if v == "" {
+			return staleAt, time.Time{}, true
+		}
+		// The transport measures the window from Date, as canStaleOnError
+		// does, not from the end of the freshness lifetime.
+		if window, err := time.ParseDuration(v + "s"); err == nil && date.Add(window).After(staleAt) {
+			discardAt = date.Add(window)
+		}
+	}
+	return staleAt, discardAt, true
+}
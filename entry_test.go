@@ -0,0 +1,136 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const dumpedResponse = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello"
+
+func TestEntryFrameRoundTrip(t *testing.T) {
+	got, err := unframeEntry(frameEntry([]byte(dumpedResponse)))
+	if err != nil || string(got) != dumpedResponse {
+		t.Fatalf("unframeEntry = %q, %v; want the original response", got, err)
+	}
+}
+
+func TestEntryFrameDetectsDamage(t *testing.T) {
+	framed := frameEntry([]byte(dumpedResponse))
+	for i := range framed {
+		damaged := bytes.Clone(framed)
+		damaged[i] ^= 0x20
+		if _, err := unframeEntry(damaged); err == nil {
+			t.Errorf("flipping byte %d went unnoticed", i)
+		}
+	}
+	for _, entry := range []string{"", "\x00hc", "garbage", string(framed[:len(framed)-1])} {
+		if _, err := unframeEntry([]byte(entry)); err == nil {
+			t.Errorf("unframeEntry(%q) accepted a damaged entry", entry)
+		}
+	}
+}
+
+// Entries stored before framing was introduced are still served.
+func TestUnframedLegacyEntryReadable(t *testing.T) {
+	c := newTestCache()
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	c.Set(cacheKey(req), []byte(dumpedResponse))
+
+	resp, err := CachedResponse(c, req)
+	if err != nil || resp == nil {
+		t.Fatalf("CachedResponse = %v, %v; want the legacy entry", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestCorruptEntryIsMissDeletedAndReported(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprint(w, "the real body")
+	}))
+	defer srv.Close()
+
+	c := newTestCache()
+	var reported []string
+	client := NewTransport(c, WithCorruptEntryHook(func(key string) {
+		reported = append(reported, key)
+	})).Client()
+	if _, _, err := get(t, client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the stored body in place, the way a misbehaving backend would.
+	key := srv.URL
+	entry, _ := c.Get(key)
+	c.Set(key, bytes.Replace(entry, []byte("real"), []byte("fake"), 1))
+
+	_, body, err := get(t, client, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "the real body" {
+		t.Errorf("body = %q, want a fresh fetch of the real body", body)
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+	if len(reported) != 1 || reported[0] != key {
+		t.Errorf("hook reported %q, want [%q]", reported, key)
+	}
+	// The refetched response replaced the damaged entry.
+	if entry, _ := c.Get(key); bytes.Contains(entry, []byte("fake")) {
+		t.Error("damaged entry is still stored")
+	}
+}
+
+func TestEntryExpiry(t *testing.T) {
+	date := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	entry := func(headers string) []byte {
+		return frameEntry([]byte("HTTP/1.1 200 OK\r\nDate: " + date.Format(http.TimeFormat) + "\r\n" + headers + "Content-Length: 0\r\n\r\n"))
+	}
+	cases := []struct {
+		name             string
+		value            []byte
+		stale, discard   time.Duration
+		neverDiscard, ok bool
+	}{
+		{"max-age", entry("Cache-Control: max-age=60\r\n"), 60 * time.Second, 60 * time.Second, false, true},
+		{"expires", entry("Expires: " + date.Add(time.Hour).Format(http.TimeFormat) + "\r\n"), time.Hour, time.Hour, false, true},
+		{"no lifetime", entry(""), 0, 0, false, true},
+		{"no-cache", entry("Cache-Control: no-cache, max-age=60\r\n"), 0, 0, false, true},
+		{"stale-if-error", entry("Cache-Control: max-age=60, stale-if-error=300\r\n"), 60 * time.Second, 300 * time.Second, false, true},
+		{"short stale-if-error", entry("Cache-Control: max-age=60, stale-if-error=30\r\n"), 60 * time.Second, 60 * time.Second, false, true},
+		{"unbounded stale-if-error", entry("Cache-Control: max-age=60, stale-if-error\r\n"), 60 * time.Second, 0, true, true},
+		{"immutable", entry("Cache-Control: max-age=60, immutable\r\n"), 0, 0, false, false},
+		{"no date", frameEntry([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")), 0, 0, false, false},
+		{"corrupt", []byte("\x00hc\x01garbage"), 0, 0, false, false},
+	}
+	for _, tc := range cases {
+		staleAt, discardAt, ok := EntryExpiry(tc.value)
+		if ok != tc.ok {
+			t.Errorf("%s: ok = %v, want %v", tc.name, ok, tc.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if want := date.Add(tc.stale); !staleAt.Equal(want) {
+			t.Errorf("%s: staleAt = %v, want %v", tc.name, staleAt, want)
+		}
+		if tc.neverDiscard {
+			if !discardAt.IsZero() {
+				t.Errorf("%s: discardAt = %v, want zero", tc.name, discardAt)
+			}
+		} else if want := date.Add(tc.discard); !discardAt.Equal(want) {
+			t.Errorf("%s: discardAt = %v, want %v", tc.name, discardAt, want)
+		}
+	}
+}
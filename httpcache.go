@@ -19,6 +19,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/sync/singleflight"
@@ -78,8 +79,8 @@ func cacheKey(req *http.Request) string {
 // Sharing one upstream response between requests whose headers differ would
 // serve one caller another caller's response: two concurrent requests to the
 // same URL with different Authorization headers must never be collapsed. Only
-// cacheable GET/HEAD requests are ever deduplicated, so the request body is
-// not part of the key.
+// cacheable requests are ever deduplicated, and the key of a cached POST
+// already carries a digest of its body, so the body is not hashed again.
 func flightKey(key string, req *http.Request) string {
 	names := make([]string, 0, len(req.Header))
 	for name := range req.Header {
@@ -118,16 +119,43 @@ func flightKey(key string, req *http.Request) string {
 }
 
 // CachedResponse returns the cached http.Response for req if present, and nil
-// otherwise.
+// otherwise. It looks under the plain key, so it does not see entries a
+// Transport with KeyHeaders stored in a partition. An entry that fails its
+// integrity check is deleted and reported as absent.
 func CachedResponse(c Cache, req *http.Request) (resp *http.Response, err error) {
-	cachedVal, ok := c.Get(cacheKey(req))
+	return cachedResponse(c, cacheKey(req), req, nil)
+}
+
+// cachedResponse reads and verifies the entry stored under key, calling
+// onCorrupt, if set, when it has to discard a damaged one.
+func cachedResponse(c Cache, key string, req *http.Request, onCorrupt func(key string)) (*http.Response, error) {
+	cachedVal, ok := c.Get(key)
 	if !ok {
-		return
+		return nil, nil
+	}
+	response, err := unframeEntry(cachedVal)
+	if err != nil {
+		c.Delete(key)
+		if onCorrupt != nil {
+			onCorrupt(key)
+		}
+		return nil, nil
 	}
-	b := bytes.NewBuffer(cachedVal)
+	b := bytes.NewBuffer(response)
 	return http.ReadResponse(bufio.NewReaderSize(b, b.Len()), req)
 }
 
+// cachedResponse returns the response cached under key, reporting a discarded
+// entry to OnCorruptEntry. rule, the Rule matching req if any, is applied to
+// it.
+func (t *Transport) cachedResponse(key string, req *http.Request, rule *Rule) (*http.Response, error) {
+	resp, err := cachedResponse(t.Cache, key, req, t.OnCorruptEntry)
+	if resp != nil && err == nil {
+		rule.rewrite(resp.Header, false)
+	}
+	return resp, err
+}
+
 // Transport is an implementation of http.RoundTripper that will return values from a cache
 // where possible (avoiding a network request) and will additionally add validators (etag/if-modified-since)
 // to repeated requests allowing servers to return 304 / Not Modified
@@ -147,6 +175,69 @@ type Transport struct {
 	// ceiling, which lets a single response consume memory proportional to
 	// its size — only sensible when every origin is trusted and bounded.
 	MaxCacheableBytes int64
+	// DetectOffline treats a failure to dial the origin as the network being
+	// unavailable for that request: a cached entry is served regardless of
+	// freshness, as it would be in offline mode, and a cacheable request with
+	// nothing cached gets a synthetic 504 rather than the dial error. The
+	// entry is never deleted because of such a failure.
+	DetectOffline bool
+	// Breaker, if set, stops requests to an origin that keeps failing. See
+	// CircuitBreaker.
+	Breaker *CircuitBreaker
+	// RespectRetryAfter makes the transport honour Retry-After on 429 and
+	// 503 responses. Until the deadline passes no request is sent to that
+	// host: a request with a cached response behind it is answered from the
+	// cache, however stale, and anything else gets a synthetic 429 carrying
+	// the remaining wait.
+	RespectRetryAfter bool
+	// CoalesceMisses shares one upstream request between concurrent GETs
+	// that miss the cache for the same URL and headers. The body is not
+	// buffered first: every caller streams it as it arrives, through a
+	// shared window of CoalesceWindowBytes. A caller that falls a whole
+	// window behind the others is detached and finishes with a fetch of its
+	// own rather than holding everyone back.
+	CoalesceMisses bool
+	// CoalesceWindowBytes bounds the memory one coalesced response may hold.
+	// Zero, or a negative value, selects DefaultCoalesceWindowBytes.
+	CoalesceWindowBytes int64
+	// RefreshAhead, between 0 and 1, revalidates a fresh entry in the
+	// background once it is served within that final fraction of its
+	// freshness lifetime, so a popular entry is renewed before it goes stale
+	// and no caller ever waits on it. The caller that triggers the refresh is
+	// served the still-fresh entry at once. Zero disables it.
+	RefreshAhead float64
+	// OnCorruptEntry, if set, is called with the key of a stored entry that
+	// failed its integrity check. The entry has already been deleted and the
+	// request carries on as a miss; the hook is for noticing that the
+	// backend is damaging what it stores.
+	OnCorruptEntry func(key string)
+	// KeyHeaders partitions the cache by the values of these request
+	// headers, such as Authorization or Cookie, so a response fetched with
+	// one user's credentials is never served to another. Requests carrying
+	// none of them share one partition. A non-cacheable request, such as a
+	// POST, invalidates only its own partition's entry.
+	KeyHeaders []string
+	// Rules override caching policy per host and path. The first rule that
+	// matches a request applies to it; see Rule.
+	Rules []Rule
+	// CacheableStatuses lists the status codes of responses that may be
+	// stored. Nil selects DefaultCacheableStatuses.
+	CacheableStatuses []int
+	// CachePOST caches POST requests, for APIs that answer queries through
+	// POST, such as GraphQL or search. The body is read, up to
+	// MaxQueryBodyBytes, and a digest of it is added to the cache key; the
+	// request is then sent upstream with the body intact. A response is only
+	// stored if it carries max-age or Expires, which a Rule's TTL can supply
+	// for an endpoint that sends neither.
+	CachePOST bool
+
+	offline   atomic.Bool
+	backoff   backoffTable
+	flightsMu sync.Mutex
+	flights   map[string]*fanout
+	// refreshing holds the flight keys of refresh-ahead revalidations in
+	// progress.
+	refreshing sync.Map
 }
 
 // DefaultMaxCacheableBytes is the ceiling applied when a Transport leaves
@@ -188,6 +279,22 @@ func (t *Transport) roundTripper() http.RoundTripper {
 	return defaultTransport()
 }
 
+// upstream sends req to the origin. Every request that leaves the transport
+// goes through here, so this is where per-origin state — Retry-After
+// deadlines and the circuit breaker — is both consulted and updated.
+func (t *Transport) upstream(req *http.Request) (*http.Response, error) {
+	if t.RespectRetryAfter {
+		if wait := t.backoff.remaining(req.URL.Host); wait > 0 {
+			return nil, &retryAfterError{host: req.URL.Host, wait: wait}
+		}
+	}
+	resp, err := t.roundTripBreaker(req)
+	if err == nil && t.RespectRetryAfter {
+		t.noteRetryAfter(req, resp)
+	}
+	return resp, err
+}
+
 // do performs req, optionally deduplicating it against identical in-flight
 // requests.
 //
@@ -204,18 +311,20 @@ func (t *Transport) roundTripper() http.RoundTripper {
 // multi-gigabyte download or an endless event stream, and buffering it would
 // hold the whole thing in memory and withhold every byte from the caller until
 // the origin finished. Those stream instead, at the cost of letting concurrent
-// first-time requests for the same URL each reach the origin.
+// first-time requests for the same URL each reach the origin — unless
+// CoalesceMisses is set, in which case they share a fetch through coalesce,
+// which streams to every caller instead of buffering.
 // errTooLargeToShare reports that a response exceeded MaxCacheableBytes and so
 // was not buffered for deduplication. It never reaches the caller.
 var errTooLargeToShare = errors.New("httpcache: response too large to share")
 
-func (t *Transport) do(key string, req *http.Request, dedup bool) (*http.Response, error) {
+func (t *Transport) do(key string, req *http.Request, rule *Rule, dedup bool) (*http.Response, error) {
 	if !dedup {
-		return t.roundTripper().RoundTrip(req)
+		return t.upstream(req)
 	}
 
 	v, err, _ := t.singleflight.Do(flightKey(key, req), func() (interface{}, error) {
-		resp, err := t.roundTripper().RoundTrip(req)
+		resp, err := t.upstream(req)
 		if err != nil {
 			return nil, err
 		}
@@ -225,7 +334,7 @@ func (t *Transport) do(key string, req *http.Request, dedup bool) (*http.Respons
 		if resp.Body != nil {
 			defer resp.Body.Close()
 
-			if limit := t.maxCacheableBytes(); limit >= 0 {
+			if limit := t.cacheableLimit(rule); limit >= 0 {
 				// Sharing means holding the whole body in memory, so apply the
 				// same ceiling the cache uses. Read one byte past it to tell
 				// "at the limit" from "over" it.
@@ -245,13 +354,13 @@ func (t *Transport) do(key string, req *http.Request, dedup bool) (*http.Respons
 		// Too large to hold for the group: nothing was shared, so every caller
 		// fetches for itself and streams the result.
 		if errors.Is(err, errTooLargeToShare) {
-			return t.roundTripper().RoundTrip(req)
+			return t.upstreamAgain(req)
 		}
 		// The leader may have been cancelled by its own caller. If this
 		// caller's context is still live, make its own attempt instead of
 		// inheriting an unrelated cancellation.
 		if errors.Is(err, context.Canceled) && req.Context().Err() == nil {
-			return t.roundTripper().RoundTrip(req)
+			return t.upstreamAgain(req)
 		}
 		return nil, err
 	}
@@ -260,10 +369,37 @@ func (t *Transport) do(key string, req *http.Request, dedup bool) (*http.Respons
 	return http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
 }
 
+// upstreamAgain sends req to the origin after a shared attempt that may
+// have read its body. A cached POST carries GetBody from queryBody, so its
+// body is replayed rather than sent empty.
+func (t *Transport) upstreamAgain(req *http.Request) (*http.Response, error) {
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(req.Context())
+		req.Body = body
+	}
+	return t.upstream(req)
+}
+
 type CacheOption func(*cacheParams)
 type cacheParams struct {
 	markResponse      bool
 	maxCacheableBytes int64
+	offline           bool
+	detectOffline     bool
+	breaker           *CircuitBreaker
+	retryAfter        bool
+	coalesce          bool
+	coalesceWindow    int64
+	refreshAhead      float64
+	onCorruptEntry    func(key string)
+	keyHeaders        []string
+	rules             []Rule
+	cacheableStatuses []int
+	cachePOST         bool
 }
 
 func WithMarkedResponses(mark bool) CacheOption {
@@ -281,6 +417,90 @@ func WithMaxCacheableBytes(n int64) CacheOption {
 	}
 }
 
+// WithOffline starts the transport in offline mode. See Transport.SetOffline.
+func WithOffline(offline bool) CacheOption {
+	return func(params *cacheParams) {
+		params.offline = offline
+	}
+}
+
+// WithOfflineDetection sets Transport.DetectOffline.
+func WithOfflineDetection(detect bool) CacheOption {
+	return func(params *cacheParams) {
+		params.detectOffline = detect
+	}
+}
+
+// WithCircuitBreaker sets Transport.Breaker.
+func WithCircuitBreaker(b *CircuitBreaker) CacheOption {
+	return func(params *cacheParams) {
+		params.breaker = b
+	}
+}
+
+// WithRetryAfter sets Transport.RespectRetryAfter.
+func WithRetryAfter(respect bool) CacheOption {
+	return func(params *cacheParams) {
+		params.retryAfter = respect
+	}
+}
+
+// WithMissCoalescing sets Transport.CoalesceMisses.
+func WithMissCoalescing(coalesce bool) CacheOption {
+	return func(params *cacheParams) {
+		params.coalesce = coalesce
+	}
+}
+
+// WithCoalesceWindowBytes sets Transport.CoalesceWindowBytes.
+func WithCoalesceWindowBytes(n int64) CacheOption {
+	return func(params *cacheParams) {
+		params.coalesceWindow = n
+	}
+}
+
+// WithRefreshAhead sets Transport.RefreshAhead.
+func WithRefreshAhead(fraction float64) CacheOption {
+	return func(params *cacheParams) {
+		params.refreshAhead = fraction
+	}
+}
+
+// WithCorruptEntryHook sets Transport.OnCorruptEntry.
+func WithCorruptEntryHook(fn func(key string)) CacheOption {
+	return func(params *cacheParams) {
+		params.onCorruptEntry = fn
+	}
+}
+
+// WithKeyHeaders sets Transport.KeyHeaders.
+func WithKeyHeaders(names ...string) CacheOption {
+	return func(params *cacheParams) {
+		params.keyHeaders = append(params.keyHeaders, names...)
+	}
+}
+
+// WithRules sets Transport.Rules.
+func WithRules(rules ...Rule) CacheOption {
+	return func(params *cacheParams) {
+		params.rules = append(params.rules, rules...)
+	}
+}
+
+// WithCacheableStatuses sets Transport.CacheableStatuses.
+func WithCacheableStatuses(codes ...int) CacheOption {
+	return func(params *cacheParams) {
+		params.cacheableStatuses = append(params.cacheableStatuses, codes...)
+	}
+}
+
+// WithPOSTCaching sets Transport.CachePOST.
+func WithPOSTCaching(cache bool) CacheOption {
+	return func(params *cacheParams) {
+		params.cachePOST = cache
+	}
+}
+
 // NewTransport returns a new Transport with the
 // provided Cache implementation and MarkCachedResponses set to true
 func NewTransport(c Cache, opt ...CacheOption) *Transport {
@@ -291,11 +511,24 @@ func NewTransport(c Cache, opt ...CacheOption) *Transport {
 	for _, o := range opt {
 		o(params)
 	}
-	return &Transport{
+	t := &Transport{
 		Cache:               c,
 		MarkCachedResponses: params.markResponse,
 		MaxCacheableBytes:   params.maxCacheableBytes,
+		DetectOffline:       params.detectOffline,
+		Breaker:             params.breaker,
+		RespectRetryAfter:   params.retryAfter,
+		CoalesceMisses:      params.coalesce,
+		CoalesceWindowBytes: params.coalesceWindow,
+		RefreshAhead:        params.refreshAhead,
+		OnCorruptEntry:      params.onCorruptEntry,
+		KeyHeaders:          params.keyHeaders,
+		Rules:               params.rules,
+		CacheableStatuses:   params.cacheableStatuses,
+		CachePOST:           params.cachePOST,
 	}
+	t.offline.Store(params.offline)
+	return t
 }
 
 // Client returns an *http.Client that caches responses.
@@ -326,12 +559,30 @@ func varyMatches(cachedResp *http.Response, req *http.Request) bool {
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	var err error
 	var resp *http.Response
-	cacheKey := cacheKey(req)
+	cacheKey := t.cacheKey(req)
 	cacheable := (req.Method == "GET" || req.Method == "HEAD") && req.Header.Get("range") == ""
+	if t.CachePOST && req.Method == http.MethodPost && req.Header.Get("range") == "" {
+		var digest string
+		if req, digest, err = queryBody(req); err != nil {
+			return nil, err
+		}
+		if digest != "" {
+			cacheKey += " #" + digest
+			cacheable = true
+		}
+	}
+	rule := t.rule(req)
+	if rule != nil && rule.Disable {
+		cacheable = false
+	}
+
+	if t.IsOffline() {
+		return t.roundTripOffline(cacheKey, req, rule, cacheable), nil
+	}
 
 	var cachedResp *http.Response
 	if cacheable {
-		cachedResp, err = CachedResponse(t.Cache, req)
+		cachedResp, err = t.cachedResponse(cacheKey, req, rule)
 	} else {
 		// Need to invalidate an existing value
 		t.Cache.Delete(cacheKey)
@@ -347,8 +598,17 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 			// Can only use cached value if the new request doesn't Vary significantly
 			switch getFreshness(cachedResp.Header, req.Header) {
 			case fresh:
+				if t.refreshAheadDue(cachedResp, req) {
+					t.refreshAhead(cacheKey, req)
+				}
 				return cachedResp, nil
 			case stale:
+				if req.Method == http.MethodPost {
+					// On a POST a validator is a precondition on the action
+					// (RFC 9110 section 13.1.2), not a request to revalidate,
+					// so a stale entry is simply refetched.
+					break
+				}
 				var clone *http.Request
 				// Add validators if caller hasn't already done so
 				etag := cachedResp.Header.Get("etag")
@@ -368,18 +628,12 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 				}
 			}
 		}
-		resp, err = t.do(cacheKey, req, true)
+		resp, err = t.do(cacheKey, req, rule, true)
 		if err == nil {
 			// handle 5xx family errors if can stale
 			if resp.StatusCode >= 500 && resp.StatusCode != 501 {
 				if req.Method == "GET" && canStaleOnError(cachedResp.Header, req.Header) {
-					cachedResp.Header.Add(
-						textproto.CanonicalMIMEHeaderKey("Warning"),
-						fmt.Sprintf(
-							"110 httpCache \"Response is stale\" %s",
-							time.Now().UTC().Format(time.RFC1123),
-						),
-					)
+					addWarning(cachedResp.Header, 110, "Response is stale")
 					_, _ = io.ReadAll(resp.Body)
 					_ = resp.Body.Close()
 					return cachedResp, nil
@@ -433,15 +687,37 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 			// the case stale-if-error exists for. Mirrors the resp.StatusCode
 			// >= 500 branch above.
 			if req.Method == "GET" && canStaleOnError(cachedResp.Header, req.Header) {
-				cachedResp.Header.Add(
-					textproto.CanonicalMIMEHeaderKey("Warning"),
-					fmt.Sprintf(
-						"110 httpCache \"Response is stale\" %s",
-						time.Now().UTC().Format(time.RFC1123),
-					),
-				)
+				addWarning(cachedResp.Header, 110, "Response is stale")
 				return cachedResp, nil
 			}
+			// The origin could not be dialled at all. With DetectOffline that
+			// is disconnected operation: serve what we have, whatever its
+			// age, and keep it. An entry for another variant is kept too,
+			// and the request gets the 504 offline mode would give it.
+			if t.DetectOffline && isOfflineError(err) {
+				if varyMatches(cachedResp, req) {
+					return t.serveUnvalidated(cachedResp, req, 112, "Disconnected operation"), nil
+				}
+				cachedResp.Body.Close()
+				return newGatewayTimeoutResponse(req), nil
+			}
+			// The circuit for this origin is open, so nothing was sent. That
+			// is no reason to forget the entry, and it is the best answer
+			// available.
+			if errors.Is(err, ErrCircuitOpen) {
+				if varyMatches(cachedResp, req) {
+					return t.serveUnvalidated(cachedResp, req, 111, "Revalidation failed"), nil
+				}
+				return nil, err
+			}
+			// Likewise when the origin asked us to stay away for a while.
+			var backoff *retryAfterError
+			if errors.As(err, &backoff) {
+				if varyMatches(cachedResp, req) {
+					return t.serveUnvalidated(cachedResp, req, 199, "Origin requested Retry-After"), nil
+				}
+				return newTooManyRequestsResponse(req, backoff.wait), nil
+			}
 			// delete the cache on error
 			var urlError *url.Error
 			if errors.As(err, &urlError) {
@@ -475,15 +751,39 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		{
 			// Nothing is cached for this request, so the response size is
 			// unbounded and unknown: stream it rather than buffering it to
-			// share. See do for why dedup is limited to revalidation.
-			resp, err = t.do(cacheKey, req, false)
+			// share. See do for why dedup is limited to revalidation, and
+			// coalesce for how CoalesceMisses shares it without buffering.
+			var follower bool
+			if t.CoalesceMisses && req.Method == http.MethodGet && req.Header.Get("range") == "" {
+				resp, follower, err = t.coalesce(cacheKey, req)
+			} else {
+				resp, err = t.do(cacheKey, req, rule, false)
+			}
 			if err != nil {
+				if cacheable && t.DetectOffline && isOfflineError(err) && req.Context().Err() == nil {
+					return newGatewayTimeoutResponse(req), nil
+				}
+				var backoff *retryAfterError
+				if errors.As(err, &backoff) {
+					return newTooManyRequestsResponse(req, backoff.wait), nil
+				}
 				return nil, err
 			}
+			// The caller that started a coalesced fetch stores it; everyone
+			// else just reads.
+			if follower {
+				if cacheable {
+					rule.rewrite(resp.Header, true)
+				}
+				return resp, nil
+			}
 		}
 	}
 
-	if cacheable && canStore(parseCacheControl(req.Header), parseCacheControl(resp.Header)) {
+	if cacheable {
+		rule.rewrite(resp.Header, true)
+	}
+	if cacheable && t.storable(req, resp) {
 		// Record the request values for any headers the response varies on,
 		// so varyMatches can reject a mismatched request on the way back in.
 		for _, varyKey := range headerAllCommaSepValues(resp.Header, "vary") {
@@ -492,7 +792,7 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 				resp.Header.Set("X-Varied-"+varyKey, reqValue)
 			}
 		}
-		if req.Method == http.MethodGet {
+		if req.Method != http.MethodHead {
 			// Store the body as the caller reads it, not before. Draining it
 			// here to build the cache entry would withhold every byte until
 			// the origin finished and hold the whole response in memory
@@ -502,16 +802,16 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 			toCache := *resp
 			resp.Body = &cachingReadCloser{
 				body:  resp.Body,
-				limit: t.maxCacheableBytes(),
+				limit: t.cacheableLimit(rule),
 				onEOF: func(body io.Reader) {
 					toCache.Body = io.NopCloser(body)
 					if respBytes, err := httputil.DumpResponse(&toCache, true); err == nil {
-						t.Cache.Set(cacheKey, respBytes)
+						t.Cache.Set(cacheKey, frameEntry(respBytes))
 					}
 				},
 			}
 		} else {
-			// HEAD and other cacheable methods carry no body worth streaming.
+			// A HEAD response carries no body worth streaming.
 			// DumpResponse drains resp.Body and restores it with an
 			// equivalent reader, so on success the caller still receives a
 			// readable body.
@@ -524,8 +824,8 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 				resp.Body.Close()
 				return nil, err
 			}
-			if limit := t.maxCacheableBytes(); limit < 0 || int64(len(respBytes)) <= limit {
-				t.Cache.Set(cacheKey, respBytes)
+			if limit := t.cacheableLimit(rule); limit < 0 || int64(len(respBytes)) <= limit {
+				t.Cache.Set(cacheKey, frameEntry(respBytes))
 			}
 		}
 	} else {
@@ -640,28 +940,9 @@ func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
 	}
 	currentAge := clock.since(date)
 
-	var lifetime time.Duration
+	lifetime := freshnessLifetime(respCacheControl, respHeaders, date)
 	var zeroDuration time.Duration
 
-	// If a response includes both an Expires header and a max-age directive,
-	// the max-age directive overrides the Expires header, even if the Expires header is more restrictive.
-	if maxAge, ok := respCacheControl["max-age"]; ok {
-		lifetime, err = time.ParseDuration(maxAge + "s")
-		if err != nil {
-			lifetime = zeroDuration
-		}
-	} else {
-		expiresHeader := respHeaders.Get("Expires")
-		if expiresHeader != "" {
-			expires, err := time.Parse(time.RFC1123, expiresHeader)
-			if err != nil {
-				lifetime = zeroDuration
-			} else {
-				lifetime = expires.Sub(date)
-			}
-		}
-	}
-
 	if maxAge, ok := reqCacheControl["max-age"]; ok {
 		// The client will accept a response whose age is no greater than the
 		// given number of seconds. This can only shorten the usable lifetime,
@@ -706,6 +987,28 @@ func getFreshness(respHeaders, reqHeaders http.Header) (freshness int) {
 	return stale
 }
 
+// freshnessLifetime returns how long a response dated date stays fresh
+// according to the origin, or zero if it gave no explicit lifetime.
+func freshnessLifetime(respCacheControl cacheControl, respHeaders http.Header, date time.Time) time.Duration {
+	// If a response includes both an Expires header and a max-age directive,
+	// the max-age directive overrides the Expires header, even if the Expires header is more restrictive.
+	if maxAge, ok := respCacheControl["max-age"]; ok {
+		lifetime, err := time.ParseDuration(maxAge + "s")
+		if err != nil {
+			return 0
+		}
+		return lifetime
+	}
+	if expiresHeader := respHeaders.Get("Expires"); expiresHeader != "" {
+		expires, err := time.Parse(time.RFC1123, expiresHeader)
+		if err != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
 // Returns true if either the request or the response includes the stale-if-error
 func canStaleOnError(respHeaders, reqHeaders http.Header) bool {
 	respCacheControl := parseCacheControl(respHeaders)
@@ -787,6 +1090,30 @@ func canStore(reqCacheControl, respCacheControl cacheControl) (canStore bool) {
 	return true
 }
 
+// addWarning appends a Warning header (RFC 7234 5.5) with the given code and
+// text, attributed to this cache and dated now.
+func addWarning(h http.Header, code int, text string) {
+	h.Add(
+		textproto.CanonicalMIMEHeaderKey("Warning"),
+		fmt.Sprintf("%d httpCache %q %s", code, text, time.Now().UTC().Format(time.RFC1123)),
+	)
+}
+
+// serveUnvalidated prepares cachedResp to be served without the origin having
+// validated it. It adds a Warning with the given code, plus Warning 110 if the
+// entry is past its freshness lifetime, so the caller can tell it is not
+// looking at a validated response.
+func (t *Transport) serveUnvalidated(cachedResp *http.Response, req *http.Request, code int, text string) *http.Response {
+	if t.MarkCachedResponses {
+		cachedResp.Header.Set(XFromCache, cachedResp.Header.Get("Date"))
+	}
+	if getFreshness(cachedResp.Header, req.Header) != fresh {
+		addWarning(cachedResp.Header, 110, "Response is stale")
+	}
+	addWarning(cachedResp.Header, code, text)
+	return cachedResp
+}
+
 func newGatewayTimeoutResponse(req *http.Request) *http.Response {
 	var braw bytes.Buffer
 	braw.WriteString("HTTP/1.1 504 Gateway Timeout\r\n\r\n")
@@ -9,12 +9,15 @@ module github.com/ferocious-space/httpcache/integration
 
 go 1.26
 
-replace github.com/ferocious-space/httpcache => ../
+replace (
+	github.com/ferocious-space/httpcache => ../
+	github.com/ferocious-space/httpcache/BoltCache => ../BoltCache
+)
 
 require (
-	github.com/ferocious-space/httpcache v0.0.0-00010101000000-000000000000
+	github.com/ferocious-space/httpcache v0.0.0-20261019090956-eaf09c024f73
+	github.com/ferocious-space/httpcache/BoltCache v0.0.0-00010101000000-000000000000
 	github.com/labstack/echo/v4 v4.15.4
-	go.etcd.io/bbolt v1.5.0
 )
 
 require (
@@ -23,6 +26,7 @@ require (
 	github.com/mattn/go-isatty v0.0.22 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
+	go.etcd.io/bbolt v1.5.0 // indirect
 	golang.org/x/crypto v0.53.0 // indirect
 	golang.org/x/net v0.56.0 // indirect
 	golang.org/x/sync v0.22.0 // indirect
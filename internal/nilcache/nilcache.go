@@ -0,0 +1,23 @@
+// Package nilcache holds the typed-nil check shared by the Cache wrappers.
+package nilcache
+
+import (
+	"reflect"
+
+	"github.com/ferocious-space/httpcache"
+)
+
+// IsNil reports whether c carries no usable value: either an untyped nil
+// interface, or a nil pointer/map/slice/func/channel wrapped in a non-nil
+// interface, which would panic on the first method call.
+func IsNil(c httpcache.Cache) bool {
+	if c == nil {
+		return true
+	}
+	switch v := reflect.ValueOf(c); v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
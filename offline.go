@@ -0,0 +1,52 @@
+package httpcache
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// SetOffline switches the transport in or out of offline mode.
+//
+// While offline the transport makes no upstream requests at all. A cacheable
+// request is answered from the cache regardless of freshness, marked with
+// Warning 112 ("Disconnected operation"); anything else — a miss, a Vary
+// mismatch, or a non-cacheable method — gets the same synthetic 504 that an
+// only-if-cached miss does. Nothing is deleted from the cache while offline,
+// so going back online finds every entry where it was left.
+//
+// It is safe to call concurrently with RoundTrip.
+func (t *Transport) SetOffline(offline bool) {
+	t.offline.Store(offline)
+}
+
+// IsOffline reports whether the transport is in offline mode.
+func (t *Transport) IsOffline() bool {
+	return t.offline.Load()
+}
+
+// roundTripOffline answers req without touching the network.
+func (t *Transport) roundTripOffline(key string, req *http.Request, rule *Rule, cacheable bool) *http.Response {
+	if cacheable {
+		cachedResp, err := t.cachedResponse(key, req, rule)
+		if err == nil && cachedResp != nil {
+			if varyMatches(cachedResp, req) {
+				return t.serveUnvalidated(cachedResp, req, 112, "Disconnected operation")
+			}
+			cachedResp.Body.Close()
+		}
+	}
+	return newGatewayTimeoutResponse(req)
+}
+
+// isOfflineError reports whether err means the origin could not be dialled
+// at all — no route, refused connection, failed DNS lookup — as opposed to a
+// failure partway through an exchange the origin did take part in.
+func isOfflineError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
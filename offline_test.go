@@ -0,0 +1,198 @@
+package httpcache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// primeStale caches one response from a server that declares it stale
+// immediately, and returns the server so the caller can close it.
+func primeStale(t *testing.T, tr *Transport, hits *int64) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(hits, 1)
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprint(w, "cached-body")
+	}))
+	resp, err := tr.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return srv
+}
+
+func hasWarning(resp *http.Response, code string) bool {
+	for _, w := range resp.Header.Values("Warning") {
+		if strings.HasPrefix(w, code+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOfflineServesStaleWithoutContactingOrigin(t *testing.T) {
+	var hits int64
+	c := newTestCache()
+	tr := NewTransport(c)
+	srv := primeStale(t, tr, &hits)
+	defer srv.Close()
+
+	tr.SetOffline(true)
+	resp, err := tr.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(b) != "cached-body" {
+		t.Errorf("body = %q, want the cached body", b)
+	}
+	if !hasWarning(resp, "112") || !hasWarning(resp, "110") {
+		t.Errorf("Warning = %q, want 110 and 112", resp.Header.Values("Warning"))
+	}
+	if resp.Header.Get(XFromCache) == "" {
+		t.Errorf("offline response was not marked with %s", XFromCache)
+	}
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Errorf("upstream hits = %d, want 1 (offline mode must not dial)", got)
+	}
+}
+
+func TestOfflineMissAndUnsafeMethodGetGatewayTimeout(t *testing.T) {
+	var hits int64
+	c := newTestCache()
+	tr := NewTransport(c, WithOffline(true))
+	if !tr.IsOffline() {
+		t.Fatal("WithOffline(true) did not start the transport offline")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+	}))
+	defer srv.Close()
+
+	resp, err := tr.Client().Get(srv.URL + "/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("miss status = %d, want 504", resp.StatusCode)
+	}
+
+	// A POST would normally invalidate the entry for its URL. Offline it
+	// never leaves the process, so the entry must survive.
+	c.Set(srv.URL, []byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"))
+	resp, err = tr.Client().Post(srv.URL, "text/plain", strings.NewReader("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("POST status = %d, want 504", resp.StatusCode)
+	}
+	if _, ok := c.Get(srv.URL); !ok {
+		t.Error("an entry was deleted while offline")
+	}
+	if got := atomic.LoadInt64(&hits); got != 0 {
+		t.Errorf("upstream hits = %d, want 0", got)
+	}
+}
+
+func TestDetectOfflineServesStaleOnDialFailure(t *testing.T) {
+	var hits int64
+	c := newTestCache()
+	tr := NewTransport(c, WithOfflineDetection(true))
+	srv := primeStale(t, tr, &hits)
+	target := srv.URL
+	srv.Close() // nothing listens any more: every dial is refused
+
+	resp, err := tr.Client().Get(target)
+	if err != nil {
+		t.Fatalf("dial failure was not treated as offline: %v", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "cached-body" || !hasWarning(resp, "112") {
+		t.Errorf("got %q with Warning %q, want the cached body with 112", b, resp.Header.Values("Warning"))
+	}
+	if _, ok := c.Get(target); !ok {
+		t.Error("entry was deleted after a dial failure")
+	}
+
+	resp, err = tr.Client().Get(target + "/never-cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("miss status = %d, want 504", resp.StatusCode)
+	}
+}
+
+// A dial failure on a request for another variant of a cached response must
+// not cost the entry either.
+func TestDetectOfflineKeepsEntryOnVaryMismatch(t *testing.T) {
+	c := newTestCache()
+	tr := NewTransport(c, WithOfflineDetection(true))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Vary", "Accept")
+		fmt.Fprint(w, "cached-body")
+	}))
+	target := srv.URL
+	fetch(t, tr, target, http.Header{"Accept": {"text/plain"}})
+	srv.Close()
+
+	resp := fetch(t, tr, target, http.Header{"Accept": {"application/json"}})
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, want 504", resp.StatusCode)
+	}
+	if _, ok := c.Get(target); !ok {
+		t.Error("entry was deleted after a dial failure")
+	}
+}
+
+// Without DetectOffline a dial failure is still reported to the caller.
+func TestDialFailureWithoutDetectionIsAnError(t *testing.T) {
+	var hits int64
+	tr := NewTransport(newTestCache())
+	srv := primeStale(t, tr, &hits)
+	target := srv.URL
+	srv.Close()
+
+	if _, err := tr.Client().Get(target); err == nil {
+		t.Error("got a response, want the dial error")
+	}
+}
+
+func TestIsOfflineError(t *testing.T) {
+	dial := &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	read := &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}}
+	dns := &url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{Err: "no such host", Name: "x"}}
+
+	if !isOfflineError(dial) {
+		t.Error("dial error not recognised")
+	}
+	if !isOfflineError(dns) {
+		t.Error("DNS error not recognised")
+	}
+	if isOfflineError(read) {
+		t.Error("a read error mid-exchange was treated as offline")
+	}
+	if isOfflineError(errors.New("some error")) {
+		t.Error("an arbitrary error was treated as offline")
+	}
+}
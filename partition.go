@@ -0,0 +1,47 @@
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+)
+
+// cacheKey returns the key req is cached under. With KeyHeaders set, a
+// request carrying any of those headers is given a partition of its own,
+// named by a digest of their values; a request carrying none of them uses
+// the plain key, shared by every such request.
+func (t *Transport) cacheKey(req *http.Request) string {
+	key := cacheKey(req)
+	if len(t.KeyHeaders) == 0 {
+		return key
+	}
+	h := sha256.New()
+	var n [8]byte
+	// Length-prefixed for the reason given in flightKey: without framing,
+	// one tenant could craft header values that land in another's partition.
+	write := func(s string) {
+		binary.BigEndian.PutUint64(n[:], uint64(len(s)))
+		h.Write(n[:])
+		h.Write([]byte(s))
+	}
+	present := false
+	for _, name := range t.KeyHeaders {
+		values := req.Header.Values(name)
+		if len(values) > 0 {
+			present = true
+		}
+		write(http.CanonicalHeaderKey(name))
+		binary.BigEndian.PutUint64(n[:], uint64(len(values)))
+		h.Write(n[:])
+		for _, value := range values {
+			write(value)
+		}
+	}
+	if !present {
+		return key
+	}
+	// A space cannot occur in a URL as cacheKey renders it, so no plain key
+	// can end in the partition suffix.
+	return key + " @" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,93 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyHeadersKeepTenantsApart(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprintf(w, "data-for:%s", r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(newTestCache(), WithKeyHeaders("Authorization", "Cookie"))
+	get := func(auth string) string {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	for _, auth := range []string{"alice", "bob", "", "alice", "bob", ""} {
+		if got, want := get(auth), "data-for:"+auth; got != want {
+			t.Errorf("Authorization %q: got %q, want %q", auth, got, want)
+		}
+	}
+	if got := atomic.LoadInt64(&hits); got != 3 {
+		t.Errorf("upstream hits = %d, want 3, one per tenant", got)
+	}
+}
+
+func TestKeyHeadersDigest(t *testing.T) {
+	tr := &Transport{KeyHeaders: []string{"authorization", "X-Tenant"}}
+	mk := func(h http.Header) string {
+		req, err := http.NewRequest("GET", "https://api.example.com/me", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = h
+		return tr.cacheKey(req)
+	}
+
+	if got, want := mk(http.Header{"Accept": {"*/*"}}), "https://api.example.com/me"; got != want {
+		t.Errorf("request without key headers: got key %q, want %q", got, want)
+	}
+	a := mk(http.Header{"Authorization": {"alice"}})
+	if a != mk(http.Header{"Authorization": {"alice"}, "Accept": {"*/*"}}) {
+		t.Error("a header outside KeyHeaders changed the key")
+	}
+
+	cases := []struct {
+		name string
+		a, b http.Header
+	}{
+		{
+			"different credentials",
+			http.Header{"Authorization": {"alice"}},
+			http.Header{"Authorization": {"bob"}},
+		},
+		{
+			"value moved between key headers",
+			http.Header{"Authorization": {"t"}},
+			http.Header{"X-Tenant": {"t"}},
+		},
+		{
+			"value boundary shifted",
+			http.Header{"Authorization": {"ab", "c"}},
+			http.Header{"Authorization": {"a", "bc"}},
+		},
+	}
+	for _, tc := range cases {
+		if ka, kb := mk(tc.a), mk(tc.b); ka == kb {
+			t.Errorf("%s: distinct header sets share the key %q", tc.name, ka)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// PrefetchOptions tunes Transport.Prefetch. The zero value is usable.
+type PrefetchOptions struct {
+	// Concurrency bounds how many requests are in flight at once, re-warms
+	// included. Zero or negative selects 4.
+	Concurrency int
+	// Header is sent with every request, for origins that need credentials
+	// or that vary on a header.
+	Header http.Header
+	// Rewarm keeps each stored entry warm after the first pass: it is
+	// revalidated once RewarmAt of its freshness lifetime has elapsed, and
+	// again after every refresh, until the context passed to Prefetch is
+	// done. Entries with no freshness lifetime are not re-warmed.
+	Rewarm bool
+	// RewarmAt is the fraction of the freshness lifetime after which an
+	// entry is re-warmed. Values outside (0, 1) select 0.8.
+	RewarmAt float64
+	// OnResult, if set, is called with the outcome of every request,
+	// including re-warms, as it completes. It may be called concurrently.
+	OnResult func(PrefetchResult)
+}
+
+// PrefetchResult is the outcome of prefetching one URL.
+type PrefetchResult struct {
+	URL string
+	// StatusCode is the status of the response, zero if Err is set.
+	StatusCode int
+	// Stored reports whether the cache held an entry for URL afterwards.
+	// A response the normal rules refuse to store — no-store, over
+	// MaxCacheableBytes — is fetched but not stored.
+	Stored bool
+	Err    error
+}
+
+// Prefetch fetches urls through the transport so their responses are in the
+// cache before the first real request asks for them. Every request goes
+// through RoundTrip, so exactly the usual storage rules apply, and a URL
+// already fresh in the cache costs nothing.
+//
+// It returns once every URL has been fetched, with one result per URL in the
+// order given. With Rewarm set, re-warming carries on in the background
+// until ctx is done; cancel ctx to stop it.
+func (t *Transport) Prefetch(ctx context.Context, urls []string, opts *PrefetchOptions) []PrefetchResult {
+	if opts == nil {
+		opts = &PrefetchOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+	sem := make(chan struct{}, concurrency)
+
+	// A fixed pool pulls URLs from jobs, so a long list costs Concurrency
+	// goroutines rather than one per URL. The pool still takes sem, which
+	// re-warms share, for the bound on requests in flight.
+	results := make([]PrefetchResult, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(urls)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				u := urls[i]
+				select {
+				case sem <- struct{}{}:
+				case <-ctx.Done():
+					results[i] = PrefetchResult{URL: u, Err: ctx.Err()}
+					continue
+				}
+				results[i] = t.prefetchOne(ctx, u, opts, false)
+				<-sem
+				if opts.OnResult != nil {
+					opts.OnResult(results[i])
+				}
+				if opts.Rewarm && results[i].Stored {
+					go t.rewarm(ctx, u, opts, sem)
+				}
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// prefetchOne fetches u and reads the body to EOF, which is what stores it.
+// A re-warm asks for revalidation with max-age=0, since the entry it is
+// refreshing is by design still fresh.
+func (t *Transport) prefetchOne(ctx context.Context, u string, opts *PrefetchOptions, revalidate bool) PrefetchResult {
+	res := PrefetchResult{URL: u}
+	req, err := t.prefetchRequest(ctx, u, opts)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if revalidate {
+		req.Header.Set("Cache-Control", "max-age=0")
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.StatusCode = resp.StatusCode
+	res.Err = err
+	_, res.Stored = t.Cache.Get(t.cacheKey(req))
+	return res
+}
+
+func (t *Transport) prefetchRequest(ctx context.Context, u string, opts *PrefetchOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range opts.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// rewarm refreshes u each time RewarmAt of its lifetime has passed, until
+// ctx is done or the entry stops being storable.
+func (t *Transport) rewarm(ctx context.Context, u string, opts *PrefetchOptions, sem chan struct{}) {
+	for {
+		wait, ok := t.rewarmDelay(ctx, u, opts)
+		if !ok {
+			return
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		res := t.prefetchOne(ctx, u, opts, true)
+		<-sem
+		if opts.OnResult != nil {
+			opts.OnResult(res)
+		}
+		if !res.Stored {
+			return
+		}
+	}
+}
+
+// rewarmDelay returns how long until the cached entry for u should be
+// refreshed. It reports false if there is no entry, or nothing to gain from
+// refreshing it because it has no lifetime or is immutable.
+func (t *Transport) rewarmDelay(ctx context.Context, u string, opts *PrefetchOptions) (time.Duration, bool) {
+	req, err := t.prefetchRequest(ctx, u, opts)
+	if err != nil {
+		return 0, false
+	}
+	cachedResp, err := t.cachedResponse(t.cacheKey(req), req, t.rule(req))
+	if err != nil || cachedResp == nil {
+		return 0, false
+	}
+	cachedResp.Body.Close()
+
+	respCacheControl := parseCacheControl(cachedResp.Header)
+	if respCacheControl.Have("immutable") || respCacheControl.Have("no-cache") {
+		return 0, false
+	}
+	date, err := Date(cachedResp.Header)
+	if err != nil {
+		return 0, false
+	}
+	lifetime := freshnessLifetime(respCacheControl, cachedResp.Header, date)
+	if lifetime <= 0 {
+		return 0, false
+	}
+	at := opts.RewarmAt
+	if at <= 0 || at >= 1 {
+		at = 0.8
+	}
+	// Date has one-second resolution, so a just-refreshed entry can already
+	// look up to a second old. Without a floor a short lifetime would be
+	// re-warmed back to back.
+	return max(time.Second, time.Duration(float64(lifetime)*at)-clock.since(date)), true
+}
@@ -0,0 +1,155 @@
+package httpcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrefetchWarmsCache(t *testing.T) {
+	var hits, inflight, maxInflight int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			m := atomic.LoadInt64(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt64(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "no-store")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		}
+		if r.Header.Get("X-Tenant") != "acme" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(newTestCache())
+	urls := []string{srv.URL + "/a", srv.URL + "/b", srv.URL + "/c", srv.URL + "/d", srv.URL + "/private", "://bad"}
+	results := tr.Prefetch(context.Background(), urls, &PrefetchOptions{
+		Concurrency: 2,
+		Header:      http.Header{"X-Tenant": {"acme"}},
+	})
+
+	if len(results) != len(urls) {
+		t.Fatalf("got %d results, want %d", len(results), len(urls))
+	}
+	for i, res := range results[:4] {
+		if res.URL != urls[i] || res.Err != nil || res.StatusCode != http.StatusOK || !res.Stored {
+			t.Errorf("result %d = %+v, want a stored 200 for %s", i, res, urls[i])
+		}
+	}
+	if res := results[4]; res.Err != nil || res.Stored {
+		t.Errorf("no-store result = %+v, want fetched but not stored", res)
+	}
+	if res := results[5]; res.Err == nil {
+		t.Errorf("malformed URL result = %+v, want an error", res)
+	}
+	if got := atomic.LoadInt64(&maxInflight); got > 2 {
+		t.Errorf("%d requests were in flight at once, want at most 2", got)
+	}
+
+	before := atomic.LoadInt64(&hits)
+	req, _ := http.NewRequest("GET", srv.URL+"/a", nil)
+	req.Header.Set("X-Tenant", "acme")
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(XFromCache) == "" || atomic.LoadInt64(&hits) != before {
+		t.Error("a prefetched URL was not served from cache")
+	}
+}
+
+func TestPrefetchRewarmsBeforeStale(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=2")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprint(w, "warm")
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var rewarms []PrefetchResult
+	tr := NewTransport(newTestCache())
+	tr.Prefetch(ctx, []string{srv.URL}, &PrefetchOptions{
+		Rewarm:   true,
+		RewarmAt: 0.5,
+		OnResult: func(res PrefetchResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			rewarms = append(rewarms, res)
+		},
+	})
+
+	time.Sleep(1600 * time.Millisecond)
+	if got := atomic.LoadInt64(&hits); got < 2 {
+		t.Fatalf("upstream hits = %d, want the entry re-warmed at least once", got)
+	}
+	mu.Lock()
+	last := rewarms[len(rewarms)-1]
+	mu.Unlock()
+	if last.Err != nil || !last.Stored {
+		t.Errorf("last re-warm = %+v, want stored", last)
+	}
+
+	// Re-warming stops with the context.
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	stopped := atomic.LoadInt64(&hits)
+	time.Sleep(1500 * time.Millisecond)
+	if got := atomic.LoadInt64(&hits); got != stopped {
+		t.Errorf("re-warming continued after cancel: %d more requests", got-stopped)
+	}
+}
+
+// A long URL list must not cost a goroutine per URL while it waits.
+func TestPrefetchUsesBoundedGoroutines(t *testing.T) {
+	release := make(chan struct{})
+	var inflight int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&inflight, 1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+	}))
+	defer srv.Close()
+
+	urls := make([]string, 1000)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/%d", srv.URL, i)
+	}
+	before := runtime.NumGoroutine()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewTransport(newTestCache()).Prefetch(context.Background(), urls, &PrefetchOptions{Concurrency: 2})
+	}()
+	for atomic.LoadInt64(&inflight) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine() - before; n > 100 {
+		t.Errorf("%d goroutines while prefetching %d URLs two at a time", n, len(urls))
+	}
+	close(release)
+	<-done
+}
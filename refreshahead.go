@@ -0,0 +1,73 @@
+package httpcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// refreshAheadDue reports whether a fresh cachedResp has entered the final
+// RefreshAhead fraction of its freshness lifetime. The lifetime is the
+// origin's, as computed for getFreshness.
+func (t *Transport) refreshAheadDue(cachedResp *http.Response, req *http.Request) bool {
+	if t.RefreshAhead <= 0 {
+		return false
+	}
+	if parseCacheControl(req.Header).Have("only-if-cached") {
+		// The caller asked us not to touch the network on its behalf.
+		return false
+	}
+	respCacheControl := parseCacheControl(cachedResp.Header)
+	if respCacheControl.Have("immutable") {
+		return false
+	}
+	date, err := Date(cachedResp.Header)
+	if err != nil {
+		return false
+	}
+	lifetime := freshnessLifetime(respCacheControl, cachedResp.Header, date)
+	if lifetime <= 0 {
+		return false
+	}
+	lead := time.Duration(float64(lifetime) * min(t.RefreshAhead, 1))
+	return clock.since(date) >= lifetime-lead
+}
+
+// refreshAhead revalidates the entry for req in the background, so that the
+// next caller finds it fresh instead of waiting on a revalidation. At most
+// one refresh per flight key runs at a time; a hit that finds one already
+// under way leaves it be.
+//
+// The refresh outlives the request that triggered it, so it keeps that
+// request's values but not its cancellation.
+func (t *Transport) refreshAhead(key string, req *http.Request) {
+	fk := flightKey(key, req)
+	if _, busy := t.refreshing.LoadOrStore(fk, struct{}{}); busy {
+		return
+	}
+	refresh := req.Clone(context.WithoutCancel(req.Context()))
+	if req.GetBody != nil {
+		// A cached POST. The clone would share req's body, which may
+		// already have been read; give the refresh its own copy.
+		body, err := req.GetBody()
+		if err != nil {
+			t.refreshing.Delete(fk)
+			return
+		}
+		refresh.Body = body
+	}
+	// max-age=0 makes RoundTrip treat the entry as stale and send it
+	// upstream with its validators, exactly as a normal revalidation would.
+	refresh.Header.Set("Cache-Control", "max-age=0")
+	go func() {
+		defer t.refreshing.Delete(fk)
+		resp, err := t.RoundTrip(refresh)
+		if err != nil {
+			return
+		}
+		// Reading to EOF is what stores the refreshed entry.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
@@ -0,0 +1,117 @@
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// refreshIdle reports whether no refresh-ahead is in flight.
+func refreshIdle(tr *Transport) bool {
+	idle := true
+	tr.refreshing.Range(func(_, _ any) bool {
+		idle = false
+		return false
+	})
+	return idle
+}
+
+func TestRefreshAheadRenewsHotEntryInBackground(t *testing.T) {
+	defer func() { clock = &realClock{} }()
+
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&hits, 1)
+		if n > 1 {
+			time.Sleep(200 * time.Millisecond) // keep the refresh in flight
+		}
+		w.Header().Set("Cache-Control", "max-age=10")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprintf(w, "version-%d", n)
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(newTestCache(), WithRefreshAhead(0.5))
+	client := tr.Client()
+	if _, _, err := get(t, client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not yet in the final half of the lifetime: no refresh.
+	clock = &fakeClock{elapsed: 2 * time.Second}
+	if _, _, err := get(t, client, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Fatalf("upstream hits = %d, want 1 before the refresh window", got)
+	}
+
+	// Inside the window: every caller is served at once from cache, and
+	// between them they trigger exactly one refresh.
+	clock = &fakeClock{elapsed: 6 * time.Second}
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			resp, body, err := get(t, client, srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if body != "version-1" || resp.Header.Get(XFromCache) == "" {
+				t.Errorf("got %q, want the still-fresh cached version-1", body)
+			}
+			if d := time.Since(start); d > 150*time.Millisecond {
+				t.Errorf("a fresh hit waited %v for the refresh", d)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !refreshIdle(tr) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2 (one deduplicated refresh)", got)
+	}
+
+	clock = &fakeClock{elapsed: 0}
+	_, body, err := get(t, client, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "version-2" {
+		t.Errorf("after the refresh got %q, want version-2", body)
+	}
+}
+
+func TestRefreshAheadDisabledByDefault(t *testing.T) {
+	defer func() { clock = &realClock{} }()
+	clock = &fakeClock{elapsed: 59 * time.Second}
+
+	tr := NewTransport(newTestCache())
+	resp := &http.Response{Header: http.Header{
+		"Cache-Control": {"max-age=60"},
+		"Date":          {time.Now().UTC().Format(time.RFC1123)},
+	}}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if tr.refreshAheadDue(resp, req) {
+		t.Error("refresh-ahead fired with RefreshAhead unset")
+	}
+	tr.RefreshAhead = 0.1
+	if !tr.refreshAheadDue(resp, req) {
+		t.Error("refresh-ahead did not fire inside the final tenth of the lifetime")
+	}
+	req.Header.Set("Cache-Control", "only-if-cached")
+	if tr.refreshAheadDue(resp, req) {
+		t.Error("refresh-ahead fired for an only-if-cached request")
+	}
+}
@@ -0,0 +1,110 @@
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backoffTable remembers, per host, when an origin that answered 429 or 503
+// with Retry-After is willing to hear from us again.
+type backoffTable struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// remaining returns how long requests to host must still be held back, or
+// zero if they may proceed.
+func (b *backoffTable) remaining(host string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[host]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(until)
+	if wait <= 0 {
+		delete(b.until, host)
+		return 0
+	}
+	return wait
+}
+
+// hold records that host asked not to be contacted until until. A later
+// deadline already on record is kept.
+func (b *backoffTable) hold(host string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.until == nil {
+		b.until = make(map[string]time.Time)
+	}
+	if cur, ok := b.until[host]; !ok || until.After(cur) {
+		b.until[host] = until
+	}
+}
+
+// retryAfterError reports that a request was not sent because its origin's
+// Retry-After deadline has not passed. It is always turned into a response
+// before RoundTrip returns.
+type retryAfterError struct {
+	host string
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("httpcache: %s asked for no requests for another %v", e.host, e.wait)
+}
+
+// parseRetryAfter interprets a Retry-After header (RFC 9110 10.2.3), which is
+// either a number of seconds or an HTTP-date. It returns false for an absent,
+// malformed, or already-past value.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if secs <= 0 || secs > int64(math.MaxInt64/time.Second) {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, false
+}
+
+// noteRetryAfter records the deadline carried by a 429 or 503 response.
+func (t *Transport) noteRetryAfter(req *http.Request, resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	now := time.Now()
+	if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+		t.backoff.hold(req.URL.Host, now.Add(wait))
+	}
+}
+
+// newTooManyRequestsResponse synthesizes the 429 returned in place of a
+// request held back by Retry-After, carrying the time left to wait.
+func newTooManyRequestsResponse(req *http.Request, wait time.Duration) *http.Response {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	var braw bytes.Buffer
+	fmt.Fprintf(&braw, "HTTP/1.1 429 Too Many Requests\r\nRetry-After: %d\r\nContent-Length: 0\r\n\r\n", secs)
+	resp, err := http.ReadResponse(bufio.NewReader(&braw), req)
+	if err != nil {
+		panic(err)
+	}
+	return resp
+}
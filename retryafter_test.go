@@ -0,0 +1,130 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterHoldsBackRequests(t *testing.T) {
+	var hits int64
+	var limited atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		if limited.Load() {
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Date", time.Now().UTC().Format(time.RFC1123))
+		fmt.Fprint(w, "cached-body")
+	}))
+	defer srv.Close()
+
+	tr := NewTransport(newTestCache(), WithRetryAfter(true))
+	client := tr.Client()
+	if _, _, err := get(t, client, srv.URL+"/cached"); err != nil {
+		t.Fatal(err)
+	}
+
+	limited.Store(true)
+	resp, _, err := get(t, client, srv.URL+"/trigger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want the origin's 429", resp.StatusCode)
+	}
+	before := atomic.LoadInt64(&hits)
+
+	// A stale entry is served rather than revalidated.
+	resp, body, err := get(t, client, srv.URL+"/cached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "cached-body" || !hasWarning(resp, "110") || !hasWarning(resp, "199") {
+		t.Errorf("got %d %q with Warning %q, want the stale cached body with 110 and 199",
+			resp.StatusCode, body, resp.Header.Values("Warning"))
+	}
+
+	// Nothing cached: a synthetic 429 that still tells the caller how long.
+	resp, _, err = get(t, client, srv.URL+"/uncached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want a synthetic 429", resp.StatusCode)
+	}
+	if ra := resp.Header.Get("Retry-After"); ra != "120" && ra != "119" {
+		t.Errorf("Retry-After = %q, want the remaining wait of about 120s", ra)
+	}
+
+	if got := atomic.LoadInt64(&hits); got != before {
+		t.Errorf("origin saw %d requests during Retry-After, want 0", got-before)
+	}
+
+	// Once the deadline passes, requests flow again.
+	tr.backoff.mu.Lock()
+	tr.backoff.until[srv.Listener.Addr().String()] = time.Now().Add(-time.Second)
+	tr.backoff.mu.Unlock()
+	limited.Store(false)
+	if _, _, err := get(t, client, srv.URL+"/uncached"); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt64(&hits); got != before+1 {
+		t.Errorf("origin saw %d requests after the deadline, want 1", got-before)
+	}
+}
+
+// Without the option, a 429 changes nothing about the next request.
+func TestRetryAfterIgnoredByDefault(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := NewTransport(newTestCache()).Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(fmt.Sprintf("%s/%d", srv.URL, i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"30", 30 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, false},
+		{"0", 0, false},
+		{"-3", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := parseRetryAfter(tc.in, now)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package httpcache
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Rule overrides caching policy for the requests it matches, for origins
+// that send no caching headers or the wrong ones. Its overrides are applied
+// to the response headers, both as a response arrives from the origin and as
+// a stored one is read back, before freshness and storability are decided;
+// the caller sees the rewritten headers too.
+type Rule struct {
+	// Host matches the request's host name, case-insensitively. A leading
+	// "*." matches any subdomain, and a pattern with a port matches the
+	// host and port. An IPv6 address may be written with or without
+	// brackets, but needs them to carry a port. Empty matches every host.
+	Host string
+	// Path matches the request's URL path. A trailing "*" matches any
+	// suffix, so "/static/*" covers everything below /static/; otherwise
+	// the path must match exactly. Empty matches every path.
+	Path string
+
+	// Disable stops matching requests from being cached at all.
+	Disable bool
+	// TTL, if positive, replaces the origin's freshness lifetime: the
+	// response is given max-age=TTL and its Expires, no-cache, and immutable
+	// are dropped. A response without a Date is dated on arrival.
+	TTL time.Duration
+	// IgnoreNoStore drops the origin's no-store, so the response is stored
+	// anyway. A no-store sent by the caller is still honoured.
+	IgnoreNoStore bool
+	// IgnoreVary removes these header names from the response's Vary, for
+	// origins that vary on headers that do not change the content.
+	IgnoreVary []string
+	// MaxCacheableBytes, if not zero, replaces Transport.MaxCacheableBytes
+	// for matching requests.
+	MaxCacheableBytes int64
+	// StaleIfError, if positive, is the stale-if-error window given to
+	// responses whose origin did not send one.
+	StaleIfError time.Duration
+}
+
+// matches reports whether r applies to req.
+func (r *Rule) matches(req *http.Request) bool {
+	if r.Host != "" {
+		pattern := r.Host
+		if h, port, err := net.SplitHostPort(r.Host); err == nil {
+			if req.URL.Port() != port {
+				return false
+			}
+			pattern = h
+		}
+		pattern = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]"))
+		host := strings.ToLower(req.URL.Hostname())
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if !strings.HasSuffix(host, suffix) || len(host) == len(suffix) {
+				return false
+			}
+		} else if host != pattern {
+			return false
+		}
+	}
+	if r.Path != "" {
+		if prefix, ok := strings.CutSuffix(r.Path, "*"); ok {
+			return strings.HasPrefix(req.URL.Path, prefix)
+		}
+		return req.URL.Path == r.Path
+	}
+	return true
+}
+
+// rewrite applies r's header overrides to h. fromOrigin says h has just
+// arrived from the origin rather than been read from the cache, and so may
+// be dated now if it carries no Date. A nil r leaves h alone.
+func (r *Rule) rewrite(h http.Header, fromOrigin bool) {
+	if r == nil {
+		return
+	}
+	cc := parseCacheControl(h)
+	changed := false
+	if r.TTL > 0 {
+		delete(cc, "no-cache")
+		delete(cc, "immutable")
+		cc["max-age"] = strconv.FormatInt(int64(r.TTL/time.Second), 10)
+		h.Del("Expires")
+		if fromOrigin && h.Get("Date") == "" {
+			h.Set("Date", time.Now().UTC().Format(time.RFC1123))
+		}
+		changed = true
+	}
+	if r.IgnoreNoStore && cc.Have("no-store") {
+		delete(cc, "no-store")
+		changed = true
+	}
+	if r.StaleIfError > 0 && !cc.Have("stale-if-error") {
+		cc["stale-if-error"] = strconv.FormatInt(int64(r.StaleIfError/time.Second), 10)
+		changed = true
+	}
+	if changed {
+		if len(cc) == 0 {
+			h.Del("Cache-Control")
+		} else {
+			h.Set("Cache-Control", cc.String())
+		}
+	}
+
+	if len(r.IgnoreVary) > 0 && h.Get("Vary") != "" {
+		var keep []string
+		for _, name := range headerAllCommaSepValues(h, "Vary") {
+			if name != "" && !r.ignoresVary(name) {
+				keep = append(keep, name)
+			}
+		}
+		if len(keep) == 0 {
+			h.Del("Vary")
+		} else {
+			h.Set("Vary", strings.Join(keep, ", "))
+		}
+	}
+}
+
+func (r *Rule) ignoresVary(name string) bool {
+	for _, ignored := range r.IgnoreVary {
+		if strings.EqualFold(ignored, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// String renders c as a Cache-Control value, directives sorted by name.
+func (c cacheControl) String() string {
+	directives := make([]string, 0, len(c))
+	for name, value := range c {
+		if value != "" {
+			name += "=" + value
+		}
+		directives = append(directives, name)
+	}
+	sort.Strings(directives)
+	return strings.Join(directives, ", ")
+}
+
+// rule returns the first of Rules that matches req, or nil.
+func (t *Transport) rule(req *http.Request) *Rule {
+	for i := range t.Rules {
+		if t.Rules[i].matches(req) {
+			return &t.Rules[i]
+		}
+	}
+	return nil
+}
+
+// cacheableLimit resolves the largest body that may be stored for a request
+// matched by rule, which may be nil, with the same meaning as
+// maxCacheableBytes.
+func (t *Transport) cacheableLimit(rule *Rule) int64 {
+	if rule != nil && rule.MaxCacheableBytes != 0 {
+		return rule.MaxCacheableBytes
+	}
+	return t.maxCacheableBytes()
+}
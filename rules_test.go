@@ -0,0 +1,185 @@
+package httpcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRuleMatches(t *testing.T) {
+	cases := []struct {
+		rule Rule
+		url  string
+		want bool
+	}{
+		{Rule{}, "https://example.com/any", true},
+		{Rule{Host: "example.com"}, "https://EXAMPLE.com/a", true},
+		{Rule{Host: "example.com"}, "https://api.example.com/a", false},
+		{Rule{Host: "*.example.com"}, "https://api.example.com/a", true},
+		{Rule{Host: "*.example.com"}, "https://example.com/a", false},
+		{Rule{Host: "*.example.com"}, "https://badexample.com/a", false},
+		{Rule{Host: "example.com:8080"}, "https://example.com:8080/a", true},
+		{Rule{Host: "example.com:8080"}, "https://example.com/a", false},
+		{Rule{Host: "example.com"}, "https://example.com:8080/a", true},
+		{Rule{Host: "[::1]"}, "http://[::1]:8080/a", true},
+		{Rule{Host: "::1"}, "http://[::1]:8080/a", true},
+		{Rule{Host: "[::1]"}, "http://[::2]/a", false},
+		{Rule{Host: "[::1]:8080"}, "http://[::1]:8080/a", true},
+		{Rule{Host: "[::1]:8080"}, "http://[::1]:9090/a", false},
+		{Rule{Host: "[::1]:8080"}, "http://[::1]/a", false},
+		{Rule{Path: "/static/*"}, "https://example.com/static/js/app.js", true},
+		{Rule{Path: "/static/*"}, "https://example.com/staticfile", false},
+		{Rule{Path: "/health"}, "https://example.com/health", true},
+		{Rule{Path: "/health"}, "https://example.com/health/db", false},
+		{Rule{Host: "example.com", Path: "/a"}, "https://other.com/a", false},
+	}
+	for _, tc := range cases {
+		req, err := http.NewRequest("GET", tc.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tc.rule.matches(req); got != tc.want {
+			t.Errorf("Rule{Host: %q, Path: %q} on %s: got %v, want %v", tc.rule.Host, tc.rule.Path, tc.url, got, tc.want)
+		}
+	}
+}
+
+// ruleOrigin serves path-dependent caching headers and counts requests.
+func ruleOrigin(t *testing.T, hits *int64, status *int64) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(hits, 1)
+		switch r.URL.Path {
+		case "/bare":
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "User-Agent, Accept")
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		if s := atomic.LoadInt64(status); s != 0 {
+			w.WriteHeader(int(s))
+		}
+		fmt.Fprint(w, strings.Repeat("x", 64))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func fetch(t *testing.T, tr *Transport, url string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRuleOverrides(t *testing.T) {
+	cases := []struct {
+		name     string
+		rule     Rule
+		path     string
+		headers  []http.Header
+		wantHits int64
+	}{
+		{"forced TTL caches a response without caching headers", Rule{TTL: time.Minute}, "/bare", nil, 1},
+		{"without a rule that response is refetched", Rule{Path: "/elsewhere", TTL: time.Minute}, "/bare", nil, 2},
+		{"ignored no-store", Rule{IgnoreNoStore: true}, "/no-store", nil, 1},
+		{"disabled", Rule{Disable: true}, "/cacheable", nil, 2},
+		{"rule MaxCacheableBytes below the body", Rule{MaxCacheableBytes: 10}, "/cacheable", nil, 2},
+		{
+			"ignored Vary header",
+			Rule{IgnoreVary: []string{"user-agent"}},
+			"/vary",
+			[]http.Header{{"User-Agent": {"a"}}, {"User-Agent": {"b"}}},
+			1,
+		},
+		{
+			"remaining Vary header still honoured",
+			Rule{IgnoreVary: []string{"User-Agent"}},
+			"/vary",
+			[]http.Header{{"Accept": {"a"}}, {"Accept": {"b"}}},
+			2,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var hits, status int64
+			srv := ruleOrigin(t, &hits, &status)
+			tr := NewTransport(newTestCache(), WithRules(tc.rule))
+			for i := range 2 {
+				var h http.Header
+				if tc.headers != nil {
+					h = tc.headers[i]
+				}
+				fetch(t, tr, srv.URL+tc.path, h)
+			}
+			if got := atomic.LoadInt64(&hits); got != tc.wantHits {
+				t.Errorf("upstream hits = %d, want %d", got, tc.wantHits)
+			}
+		})
+	}
+}
+
+func TestRuleStaleIfErrorDefault(t *testing.T) {
+	var hits, status int64
+	srv := ruleOrigin(t, &hits, &status)
+	tr := NewTransport(newTestCache(), WithRules(Rule{TTL: time.Nanosecond, StaleIfError: time.Minute}))
+
+	fetch(t, tr, srv.URL+"/bare", nil)
+	atomic.StoreInt64(&status, http.StatusInternalServerError)
+	resp := fetch(t, tr, srv.URL+"/bare", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want the stale 200", resp.StatusCode)
+	}
+	if resp.Header.Get(XFromCache) == "" {
+		t.Error("stale response was not marked as served from cache")
+	}
+}
+
+func TestFirstMatchingRuleWins(t *testing.T) {
+	var hits, status int64
+	srv := ruleOrigin(t, &hits, &status)
+	tr := NewTransport(newTestCache(), WithRules(
+		Rule{Path: "/bare", Disable: true},
+		Rule{TTL: time.Minute},
+	))
+	fetch(t, tr, srv.URL+"/bare", nil)
+	fetch(t, tr, srv.URL+"/bare", nil)
+	if got := atomic.LoadInt64(&hits); got != 2 {
+		t.Errorf("upstream hits = %d, want 2; the later rule overrode the first", got)
+	}
+}
+
+func TestRuleRewritesCacheControl(t *testing.T) {
+	h := http.Header{
+		"Cache-Control": {"no-cache, immutable, max-age=5"},
+		"Expires":       {"Thu, 01 Jan 1970 00:00:00 GMT"},
+	}
+	(&Rule{TTL: 90 * time.Second, StaleIfError: time.Hour}).rewrite(h, true)
+	if got, want := h.Get("Cache-Control"), "max-age=90, stale-if-error=3600"; got != want {
+		t.Errorf("Cache-Control: got %q, want %q", got, want)
+	}
+	if h.Get("Expires") != "" {
+		t.Error("Expires was kept alongside a forced TTL")
+	}
+	if h.Get("Date") == "" {
+		t.Error("a response from the origin without a Date was not dated")
+	}
+}